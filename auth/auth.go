@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/gorilla/mux"
+)
+
+// Token scopes. Each scope includes the permissions of the ones before it.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// tokenPrefix makes tokens easy to recognise in configs and secret scanners
+const tokenPrefix = "mds_"
+
+// CookieName is the cookie the web UI uses to authenticate page loads.
+// It is only honoured for reads, never for mutating endpoints.
+const CookieName = "api_token"
+
+var scopeLevels = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// ErrUnauthorized is returned when a request carries no valid token
+var ErrUnauthorized = errors.New("missing or invalid API token")
+
+// ErrForbidden is returned when a token's scope is too narrow
+var ErrForbidden = errors.New("API token scope is insufficient")
+
+type contextKey struct{}
+
+// ValidScope reports whether scope is one of the known scopes
+func ValidScope(scope string) bool {
+	_, ok := scopeLevels[scope]
+	return ok
+}
+
+// Allows reports whether a token with scope may perform an action requiring required
+func Allows(scope, required string) bool {
+	return scopeLevels[scope] >= scopeLevels[required]
+}
+
+// HashToken returns the hex SHA-256 of a plaintext token, as kept in the store
+func HashToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken generates a new token, stores its hash and returns the plaintext.
+// The plaintext is not kept anywhere and cannot be recovered later.
+func IssueToken(store db.TokenStore, name, scope string, groups []string) (string, db.Token, error) {
+	if !ValidScope(scope) {
+		return "", db.Token{}, fmt.Errorf("unknown scope %q (use read, write or admin)", scope)
+	}
+	id, err := randomHex(6)
+	if err != nil {
+		return "", db.Token{}, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", db.Token{}, err
+	}
+	plain := tokenPrefix + id + "_" + secret
+	token := db.Token{
+		ID:        id,
+		Name:      name,
+		Hash:      HashToken(plain),
+		Scope:     scope,
+		Groups:    groups,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := store.CreateToken(token); err != nil {
+		return "", db.Token{}, err
+	}
+	return plain, token, nil
+}
+
+// Authenticate resolves the token carried by the request.
+// It returns ErrUnauthorized if there is no token or it is unknown or revoked.
+func Authenticate(store db.TokenStore, r *http.Request) (db.Token, error) {
+	return lookup(store, TokenFromRequest(r))
+}
+
+func lookup(store db.TokenStore, plain string) (db.Token, error) {
+	if plain == "" {
+		return db.Token{}, ErrUnauthorized
+	}
+	token, err := store.GetTokenByHash(HashToken(plain))
+	if err != nil || token.Revoked {
+		return db.Token{}, ErrUnauthorized
+	}
+	return token, nil
+}
+
+// TokenFromRequest extracts the bearer token from the Authorization header.
+// The legacy "key" query parameter used by /add is accepted as a fallback.
+func TokenFromRequest(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return r.URL.Query().Get("key")
+}
+
+// Require returns middleware that rejects requests without a token of at least the given scope
+func Require(store db.TokenStore, scope string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := Authenticate(store, r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="go-markdown-server"`)
+				writeError(w, http.StatusUnauthorized, err)
+				return
+			}
+			if !Allows(token.Scope, scope) {
+				writeError(w, http.StatusForbidden, ErrForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithToken(r.Context(), token)))
+		})
+	}
+}
+
+// Optional returns middleware that attaches the request's token to the context
+// when one is present. Anonymous requests pass through; a bad bearer token is
+// rejected so API clients notice revoked credentials.
+func Optional(store db.TokenStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if plain := TokenFromRequest(r); plain != "" {
+				token, err := lookup(store, plain)
+				if err != nil {
+					writeError(w, http.StatusUnauthorized, err)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(WithToken(r.Context(), token)))
+				return
+			}
+			// A stale cookie just means an anonymous page view
+			if c, err := r.Cookie(CookieName); err == nil {
+				if token, err := lookup(store, c.Value); err == nil {
+					r = r.WithContext(WithToken(r.Context(), token))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CanRead reports whether the token in ctx (if any) may read a collection with the given ACL
+func CanRead(ctx context.Context, meta db.CollectionMeta) bool {
+	token, ok := FromContext(ctx)
+	switch meta.Access {
+	case "", db.AccessPublic:
+		return true
+	case db.AccessAuthenticated:
+		return ok
+	case db.AccessGroups:
+		if !ok {
+			return false
+		}
+		if token.Scope == ScopeAdmin {
+			return true
+		}
+		for _, want := range meta.Groups {
+			for _, have := range token.Groups {
+				if want == have {
+					return true
+				}
+			}
+		}
+		return false
+	default:
+		// Unknown access levels fail closed
+		return ok && token.Scope == ScopeAdmin
+	}
+}
+
+// WithToken returns a copy of ctx carrying the authenticated token
+func WithToken(ctx context.Context, token db.Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, token)
+}
+
+// FromContext returns the authenticated token stored in ctx, if any
+func FromContext(ctx context.Context) (db.Token, bool) {
+	token, ok := ctx.Value(contextKey{}).(db.Token)
+	return token, ok
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
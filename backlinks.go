@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/gorilla/mux"
+)
+
+// backlink is a post linking to the one being viewed
+type backlink struct {
+	Title      string `json:"title"`
+	Collection string `json:"collection"`
+	URL        string `json:"url"`
+	Path       string `json:"path"` // Canonical URL of the linking post
+}
+
+// visibleBacklinks returns the readable posts linking to post
+func visibleBacklinks(r *http.Request, post db.Post) ([]backlink, error) {
+	posts, err := store.GetBacklinks(post.Collection, post.URL)
+	if err != nil {
+		return nil, err
+	}
+	readable := readableFilter(r)
+	links := []backlink{}
+	for _, p := range posts {
+		if readable(p.Collection) {
+			links = append(links, backlink{Title: p.Title, Collection: p.Collection, URL: p.URL, Path: postPath(p)})
+		}
+	}
+	return links, nil
+}
+
+// backlinksAPIHandler returns the posts that link to a post
+func backlinksAPIHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := findPost(r, r.URL.Query().Get("collection"), mux.Vars(r)["url"])
+	if err == db.ErrNotFound {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err == errAmbiguousPost {
+		http.Error(w, err.Error()+"; pass ?collection=", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	links, err := visibleBacklinks(r, post)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/beldmian/go-markdown-server/auth"
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/filesync"
+	"github.com/beldmian/go-markdown-server/linkcheck"
+)
+
+const usage = `Usage: goapp <command> [flags]
+
+Commands:
+  serve    Start the HTTP server (default)
+  sync     Import markdown files into the store without running the server
+  export   Write posts as JSON
+  import   Read posts written by export
+  check-links
+           Report broken links in a directory of markdown files
+  token    Manage API tokens (create, list, revoke)
+  acl      Manage collection access (get, set)
+  help     Show this message
+
+Run "goapp <command> -h" for command flags.
+`
+
+// printUsage prints the command overview
+func printUsage() {
+	fmt.Fprint(os.Stderr, usage)
+}
+
+// runCommand dispatches a CLI subcommand
+func runCommand(name string, args []string) error {
+	switch name {
+	case "serve":
+		return runServe(args)
+	case "sync":
+		return runSyncCommand(args)
+	case "export":
+		return runExportCommand(args)
+	case "import":
+		return runImportCommand(args)
+	case "check-links":
+		return runCheckLinksCommand(args)
+	case "token":
+		return runTokenCommand(args)
+	case "acl":
+		return runACLCommand(args)
+	default:
+		printUsage()
+		return fmt.Errorf("unknown command: %s", name)
+	}
+}
+
+// runSyncCommand implements `goapp sync --dir --collection --prune --dry-run`
+func runSyncCommand(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dir := fs.String("dir", syncDir, "directory to import (SYNC_DIR)")
+	collection := fs.String("collection", "", "import every file into this collection instead of one per subdirectory")
+	prefix := fs.String("prefix", contentPrefix, "prefix for collections derived from subdirectories")
+	prune := fs.Bool("prune", false, "delete synced posts whose file no longer exists")
+	dryRun := fs.Bool("dry-run", false, "show what would change without writing")
+	fs.Parse(args)
+
+	results, err := filesync.SyncAllFiles(filesync.SyncConfig{
+		RootDir:    *dir,
+		Store:      store,
+		Pipeline:   pipeline,
+		Prefix:     *prefix,
+		Collection: *collection,
+		Prune:      *prune,
+		DryRun:     *dryRun,
+	})
+
+	counts := make(map[string]int)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, r := range results {
+		counts[r.Action]++
+		if r.Action == filesync.ActionUnchanged {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s\t%s\t%s\n", r.Action, r.Collection, r.URL, r.Path, r.Error)
+	}
+	tw.Flush()
+
+	mode := ""
+	if *dryRun {
+		mode = " (dry run, nothing written)"
+	}
+	fmt.Printf("%d added, %d updated, %d unchanged, %d deleted, %d failed%s\n",
+		counts[filesync.ActionAdded], counts[filesync.ActionUpdated], counts[filesync.ActionUnchanged],
+		counts[filesync.ActionDeleted], counts[filesync.ActionFailed], mode)
+
+	if err != nil {
+		return err
+	}
+	if counts[filesync.ActionFailed] > 0 {
+		return fmt.Errorf("sync: %d files failed", counts[filesync.ActionFailed])
+	}
+	return nil
+}
+
+// runCheckLinksCommand implements `goapp check-links --dir --collection --prefix --json`.
+// The directory is imported into a scratch store, so no database is needed;
+// the exit status is non-zero when a link is broken or a file fails to import.
+func runCheckLinksCommand(args []string) error {
+	fs := flag.NewFlagSet("check-links", flag.ExitOnError)
+	dir := fs.String("dir", syncDir, "directory to check (SYNC_DIR)")
+	collection := fs.String("collection", "", "import every file into this collection instead of one per subdirectory")
+	prefix := fs.String("prefix", contentPrefix, "prefix for collections derived from subdirectories")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	scratch := db.NewMemoryStore()
+	results, err := filesync.SyncAllFiles(filesync.SyncConfig{
+		RootDir:    *dir,
+		Store:      scratch,
+		Pipeline:   pipeline,
+		Prefix:     *prefix,
+		Collection: *collection,
+	})
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]string) // collection/url -> file
+	failed := 0
+	for _, r := range results {
+		paths[r.Collection+"/"+r.URL] = r.Path
+		if r.Action == filesync.ActionFailed {
+			failed++
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.Path, r.Error)
+		}
+	}
+
+	posts, err := scratch.GetPosts()
+	if err != nil {
+		return err
+	}
+	broken := linkcheck.Check(posts)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if broken == nil {
+			broken = []linkcheck.Broken{}
+		}
+		enc.Encode(broken)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, b := range broken {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", paths[b.Collection+"/"+b.URL], b.Kind, b.Link, b.Detail)
+		}
+		tw.Flush()
+		fmt.Printf("%d files checked, %d broken links\n", len(results)-failed, len(broken))
+	}
+
+	if len(broken) > 0 || failed > 0 {
+		return fmt.Errorf("check-links: %d broken links, %d files failed", len(broken), failed)
+	}
+	return nil
+}
+
+// exportFile is the JSON layout written by export and read by import
+type exportFile struct {
+	Posts []db.Post `json:"posts"`
+}
+
+// runExportCommand implements `goapp export [--collection name] [--out file]`
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	collection := fs.String("collection", "", "export only this collection")
+	out := fs.String("out", "-", "output file (- for stdout)")
+	fs.Parse(args)
+
+	var posts []db.Post
+	var err error
+	if *collection != "" {
+		posts, err = store.GetPostsByCollection(*collection)
+	} else {
+		posts, err = store.GetPosts()
+	}
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(exportFile{Posts: posts}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d posts\n", len(posts))
+	return nil
+}
+
+// runImportCommand implements `goapp import [--in file] [--replace]`
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "-", "input file written by export (- for stdin)")
+	replace := fs.Bool("replace", false, "delete the imported collections before importing")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var data exportFile
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("import: invalid export file: %w", err)
+	}
+
+	if *replace {
+		cleared := make(map[string]bool)
+		for _, post := range data.Posts {
+			if !cleared[post.Collection] {
+				cleared[post.Collection] = true
+				if err := store.DeleteCollection(post.Collection); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, post := range data.Posts {
+		if err := store.UpsertPost(post); err != nil {
+			return fmt.Errorf("import %s/%s: %w", post.Collection, post.URL, err)
+		}
+	}
+	fmt.Printf("Imported %d posts\n", len(data.Posts))
+	return nil
+}
+
+// runTokenCommand implements `goapp token create|list|revoke`
+func runTokenCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: token create|list|revoke")
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ExitOnError)
+		name := fs.String("name", "", "human readable token name")
+		scope := fs.String("scope", auth.ScopeWrite, "token scope: read, write or admin")
+		groups := fs.String("groups", "", "comma separated groups used by collection ACLs")
+		fs.Parse(args[1:])
+		if *name == "" {
+			return fmt.Errorf("token create: --name is required")
+		}
+		plain, token, err := auth.IssueToken(store, *name, *scope, splitList(*groups))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created token %s (%s, scope=%s)\n", token.ID, token.Name, token.Scope)
+		fmt.Printf("Secret (shown once): %s\n", plain)
+		return nil
+
+	case "list":
+		tokens, err := store.ListTokens()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tGROUPS\tCREATED\tREVOKED")
+		for _, t := range tokens {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%v\n", t.ID, t.Name, t.Scope, strings.Join(t.Groups, ","), t.CreatedAt.Format("2006-01-02 15:04"), t.Revoked)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: token revoke <id>")
+		}
+		if err := store.RevokeToken(args[1]); err != nil {
+			return fmt.Errorf("failed to revoke token %s: %w", args[1], err)
+		}
+		fmt.Printf("Revoked token %s\n", args[1])
+		return nil
+
+	default:
+		return fmt.Errorf("unknown token command: %s", args[0])
+	}
+}
+
+// runACLCommand implements `goapp acl get|set`
+func runACLCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: acl get|set <collection> [--access public|authenticated|groups] [--groups a,b]")
+	}
+
+	switch args[0] {
+	case "get":
+		meta, err := store.GetCollectionMeta(args[1])
+		if err == db.ErrNotFound {
+			meta = db.CollectionMeta{Name: args[1], Access: db.AccessPublic}
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("%s: access=%s groups=%s\n", meta.Name, meta.Access, strings.Join(meta.Groups, ","))
+		return nil
+
+	case "set":
+		fs := flag.NewFlagSet("acl set", flag.ExitOnError)
+		access := fs.String("access", db.AccessPublic, "public, authenticated or groups")
+		groups := fs.String("groups", "", "comma separated groups (with --access groups)")
+		fs.Parse(args[2:])
+		meta := db.CollectionMeta{Name: args[1], Access: *access, Groups: splitList(*groups)}
+		if err := validateACL(meta); err != nil {
+			return err
+		}
+		if err := store.SetCollectionMeta(meta); err != nil {
+			return err
+		}
+		fmt.Printf("Updated ACL for %s: access=%s\n", meta.Name, meta.Access)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown acl command: %s", args[0])
+	}
+}
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
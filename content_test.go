@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/highlight"
+	"github.com/beldmian/go-markdown-server/render"
+	"github.com/beldmian/go-markdown-server/sanitize"
+	"github.com/gorilla/mux"
+)
+
+// useDefaultRendering sets up the rendering pipeline as main does without configuration
+func useDefaultRendering(t *testing.T) {
+	var err error
+	if renderer, err = render.FromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if sanitizer, err = sanitize.FromEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if highlighter, err = highlight.FromEnv(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestContentWithoutCollectionIsNotFound(t *testing.T) {
+	useDefaultRendering(t)
+	store = db.NewMemoryStore()
+	store.SetCollectionMeta(db.CollectionMeta{Name: "docs/internal", Access: db.AccessAuthenticated})
+	if err := store.UpsertPost(db.Post{Collection: "docs/internal", URL: "index", Title: "Internal", Body: "Secret plans", IsIndex: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, collection := range []string{"", "docs/internal"} {
+		r := mux.SetURLVars(httptest.NewRequest("GET", "/content/"+collection, nil), map[string]string{"collection": collection})
+		w := httptest.NewRecorder()
+		collectionContentHandler(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("/content/%s: status %d, want %d", collection, w.Code, http.StatusNotFound)
+		}
+		if strings.Contains(w.Body.String(), "Secret plans") {
+			t.Errorf("/content/%s shows a restricted index post", collection)
+		}
+	}
+}
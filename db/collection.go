@@ -0,0 +1,26 @@
+package db
+
+// Collection access levels
+const (
+	// AccessPublic collections are visible to everyone
+	AccessPublic = "public"
+	// AccessAuthenticated collections require any valid API token
+	AccessAuthenticated = "authenticated"
+	// AccessGroups collections require a token in one of the listed groups
+	AccessGroups = "groups"
+)
+
+// CollectionMeta holds per-collection settings such as the access control list
+type CollectionMeta struct {
+	Name   string   `bson:"name" json:"name"`
+	Access string   `bson:"access" json:"access"`
+	Groups []string `bson:"groups" json:"groups,omitempty"`
+}
+
+// CollectionStore keeps collection metadata
+type CollectionStore interface {
+	// GetCollectionMeta returns metadata for a collection or ErrNotFound
+	GetCollectionMeta(name string) (CollectionMeta, error)
+	// SetCollectionMeta creates or replaces metadata for a collection
+	SetCollectionMeta(meta CollectionMeta) error
+}
@@ -0,0 +1,39 @@
+// Package dbtest provides the stores that tests of store-backed code run against
+package dbtest
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+// Stores returns the stores a test runs against: a memory store always, and
+// MongoDB when MONGO_TEST_URI points at a scratch server. Tests give their
+// posts collections from Collection and delete them when done.
+func Stores(t *testing.T) map[string]db.Store {
+	t.Helper()
+	stores := map[string]db.Store{"memory": db.NewMemoryStore()}
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Log("MONGO_TEST_URI not set, skipping MongoDB")
+		return stores
+	}
+	previous := os.Getenv("MONGO_URI")
+	os.Setenv("MONGO_URI", uri)
+	defer os.Setenv("MONGO_URI", previous)
+	store, err := db.ConnectToDB()
+	if err != nil {
+		t.Fatalf("connect to %s: %v", uri, err)
+	}
+	stores["mongo"] = store
+	return stores
+}
+
+// Collection returns a collection name unique to this run, so tests sharing
+// a MongoDB server do not see each other's posts
+func Collection(name string) string {
+	return fmt.Sprintf("test-%s-%d", name, time.Now().UnixNano())
+}
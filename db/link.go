@@ -0,0 +1,13 @@
+package db
+
+// PostRef identifies a post by collection and url
+type PostRef struct {
+	Collection string `bson:"collection" json:"collection"`
+	URL        string `bson:"url" json:"url"`
+}
+
+// LinkStore answers queries over the link graph kept in Post.Links
+type LinkStore interface {
+	// GetBacklinks returns the posts linking to the given post, sorted by title
+	GetBacklinks(collectionName string, url string) ([]Post, error)
+}
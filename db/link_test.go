@@ -0,0 +1,64 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/db/dbtest"
+)
+
+// backlinkURLs returns the urls of the posts linking to collection/url
+func backlinkURLs(t *testing.T, store db.Store, collection, url string) []string {
+	t.Helper()
+	posts, err := store.GetBacklinks(collection, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, post := range posts {
+		urls = append(urls, post.URL)
+	}
+	return urls
+}
+
+func TestBacklinksFollowLinks(t *testing.T) {
+	for name, store := range dbtest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			collection := dbtest.Collection("links")
+			other := dbtest.Collection("links-other")
+			defer store.DeleteCollection(collection)
+			defer store.DeleteCollection(other)
+			upsert := func(post db.Post) {
+				if err := store.UpsertPost(post); err != nil {
+					t.Fatal(err)
+				}
+			}
+			setup := db.PostRef{Collection: collection, URL: "setup"}
+			upsert(db.Post{Collection: collection, URL: "setup", Title: "Setup", Body: "# Setup"})
+			upsert(db.Post{Collection: collection, URL: "guide", Title: "Guide", Body: "[setup](setup.md)", Links: []db.PostRef{setup}})
+			upsert(db.Post{Collection: collection, URL: "faq", Title: "FAQ", Body: "[setup](setup.md)", Links: []db.PostRef{setup}})
+			// Same url in another collection is a different post
+			upsert(db.Post{Collection: other, URL: "setup", Title: "Other setup", Body: "# Setup"})
+
+			if urls := backlinkURLs(t, store, collection, "setup"); len(urls) != 2 || urls[0] != "faq" || urls[1] != "guide" {
+				t.Errorf("backlinks of setup = %v, want faq and guide by title", urls)
+			}
+			if urls := backlinkURLs(t, store, other, "setup"); len(urls) != 0 {
+				t.Errorf("backlinks of %s/setup = %v, want none", other, urls)
+			}
+
+			// Removing the link removes the backlink
+			upsert(db.Post{Collection: collection, URL: "guide", Title: "Guide", Body: "No links any more"})
+			if urls := backlinkURLs(t, store, collection, "setup"); len(urls) != 1 || urls[0] != "faq" {
+				t.Errorf("backlinks of setup after unlinking = %v, want faq", urls)
+			}
+			// Deleting the linking post removes it too
+			if err := store.DeletePostByPath(collection, "faq"); err != nil {
+				t.Fatal(err)
+			}
+			if urls := backlinkURLs(t, store, collection, "setup"); len(urls) != 0 {
+				t.Errorf("backlinks of setup after deleting faq = %v, want none", urls)
+			}
+		})
+	}
+}
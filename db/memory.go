@@ -0,0 +1,392 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps posts in process memory. When created with NewFileStore
+// every change is also written to a JSON file so data survives restarts.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	posts     []Post // insertion order, oldest first
+	tokens    []Token
+	meta      map[string]CollectionMeta
+	revisions []Revision
+	path      string
+}
+
+// memorySnapshot is the on-disk layout of a file-backed MemoryStore
+type memorySnapshot struct {
+	Posts     []Post           `json:"posts"`
+	Tokens    []Token          `json:"tokens"`
+	Meta      []CollectionMeta `json:"collections"`
+	Revisions []Revision       `json:"revisions"`
+}
+
+// NewMemoryStore creates an empty, non-persistent store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{meta: make(map[string]CollectionMeta)}
+}
+
+// NewFileStore creates a store persisted to the JSON file at path.
+// Existing data is loaded if the file exists.
+func NewFileStore(path string) (*MemoryStore, error) {
+	s := NewMemoryStore()
+	s.path = path
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap memorySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to load store %s: %w", path, err)
+	}
+	s.posts = snap.Posts
+	s.tokens = snap.Tokens
+	s.revisions = snap.Revisions
+	for _, meta := range snap.Meta {
+		s.meta[meta.Name] = meta
+	}
+	return s, nil
+}
+
+// save writes the store to disk; callers must hold the write lock
+func (s *MemoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	snap := memorySnapshot{Posts: s.posts, Tokens: s.tokens, Revisions: s.revisions}
+	for _, meta := range s.meta {
+		snap.Meta = append(snap.Meta, meta)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// GetPosts ...
+func (s *MemoryStore) GetPosts() ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	posts := make([]Post, 0, len(s.posts))
+	for i := len(s.posts) - 1; i >= 0; i-- {
+		posts = append(posts, s.posts[i])
+	}
+	return posts, nil
+}
+
+// GetPost returns the post identified by collection name and URL
+func (s *MemoryStore) GetPost(collectionName string, url string) (Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, post := range s.posts {
+		if post.Collection == collectionName && post.URL == url {
+			return post, nil
+		}
+	}
+	return Post{}, ErrNotFound
+}
+
+// GetPostsByURL returns the posts with the given url in any collection
+func (s *MemoryStore) GetPostsByURL(url string) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	posts := []Post{}
+	for _, post := range s.posts {
+		if post.URL == url {
+			posts = append(posts, post)
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Collection < posts[j].Collection
+	})
+	return posts, nil
+}
+
+// GetIndexPost returns the index post for a collection (if exists)
+func (s *MemoryStore) GetIndexPost(collectionName string) (Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, post := range s.posts {
+		if post.IsIndex && post.Collection == collectionName {
+			return post, nil
+		}
+	}
+	return Post{}, ErrNotFound
+}
+
+// InsertPost ...
+func (s *MemoryStore) InsertPost(post Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.posts {
+		if existing.Collection == post.Collection && existing.URL == post.URL {
+			return ErrExists
+		}
+	}
+	s.posts = append(s.posts, post)
+	s.recordRevision(post)
+	return s.save()
+}
+
+// UpsertPost updates existing post or inserts new one based on collection+url
+func (s *MemoryStore) UpsertPost(post Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.posts {
+		if s.posts[i].Collection == post.Collection && s.posts[i].URL == post.URL {
+			s.posts[i] = post
+			s.recordRevision(post)
+			return s.save()
+		}
+	}
+	s.posts = append(s.posts, post)
+	s.recordRevision(post)
+	return s.save()
+}
+
+// recordRevision appends a revision if the content changed; callers must hold the write lock
+func (s *MemoryStore) recordRevision(post Post) {
+	var latest *Revision
+	for i := len(s.revisions) - 1; i >= 0; i-- {
+		if s.revisions[i].Collection == post.Collection && s.revisions[i].URL == post.URL {
+			latest = &s.revisions[i]
+			break
+		}
+	}
+	if rev, changed := nextRevision(post, latest); changed {
+		s.revisions = append(s.revisions, rev)
+	}
+}
+
+// GetCollections returns list of unique collection names
+func (s *MemoryStore) GetCollections() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, post := range s.posts {
+		if post.Collection != "" && !seen[post.Collection] {
+			seen[post.Collection] = true
+			result = append(result, post.Collection)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// GetPostsByCollection returns all posts in a collection
+func (s *MemoryStore) GetPostsByCollection(collectionName string) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var posts []Post
+	for _, post := range s.posts {
+		if post.Collection == collectionName {
+			posts = append(posts, post)
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].Weight != posts[j].Weight {
+			return posts[i].Weight < posts[j].Weight
+		}
+		return posts[i].Title < posts[j].Title
+	})
+	return posts, nil
+}
+
+// DeleteCollection removes all posts from a collection
+func (s *MemoryStore) DeleteCollection(collectionName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.posts[:0]
+	for _, post := range s.posts {
+		if post.Collection != collectionName {
+			kept = append(kept, post)
+		}
+	}
+	s.posts = kept
+	return s.save()
+}
+
+// DeletePostByPath deletes a post from the store based on collection name and URL
+func (s *MemoryStore) DeletePostByPath(collectionName string, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, post := range s.posts {
+		if post.Collection == collectionName && post.URL == url {
+			s.posts = append(s.posts[:i], s.posts[i+1:]...)
+			return s.save()
+		}
+	}
+	return fmt.Errorf("no post found with collection=%s, url=%s", collectionName, url)
+}
+
+// RenameCollection updates the collection name for all posts
+func (s *MemoryStore) RenameCollection(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.posts {
+		if s.posts[i].Collection == oldName {
+			s.posts[i].Collection = newName
+		}
+	}
+	return s.save()
+}
+
+// CreateToken stores a new token
+func (s *MemoryStore) CreateToken(token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Hash == token.Hash || t.ID == token.ID {
+			return fmt.Errorf("token %s already exists", token.ID)
+		}
+	}
+	s.tokens = append(s.tokens, token)
+	return s.save()
+}
+
+// GetTokenByHash returns the token with the given secret hash
+func (s *MemoryStore) GetTokenByHash(hash string) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return Token{}, ErrNotFound
+}
+
+// ListTokens returns all tokens, including revoked ones
+func (s *MemoryStore) ListTokens() ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Token(nil), s.tokens...), nil
+}
+
+// RevokeToken marks the token with the given ID as revoked
+func (s *MemoryStore) RevokeToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.tokens {
+		if s.tokens[i].ID == id {
+			s.tokens[i].Revoked = true
+			return s.save()
+		}
+	}
+	return ErrNotFound
+}
+
+// GetCollectionMeta returns metadata for a collection or ErrNotFound
+func (s *MemoryStore) GetCollectionMeta(name string) (CollectionMeta, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	meta, ok := s.meta[name]
+	if !ok {
+		return CollectionMeta{}, ErrNotFound
+	}
+	return meta, nil
+}
+
+// SetCollectionMeta creates or replaces metadata for a collection
+func (s *MemoryStore) SetCollectionMeta(meta CollectionMeta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[meta.Name] = meta
+	return s.save()
+}
+
+// GetRevisions returns all revisions of a post, oldest first
+func (s *MemoryStore) GetRevisions(collectionName string, url string) ([]Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var revisions []Revision
+	for _, rev := range s.revisions {
+		if rev.Collection == collectionName && rev.URL == url {
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, nil
+}
+
+// GetTagCounts returns post counts per tag and collection, sorted by tag
+func (s *MemoryStore) GetTagCounts() ([]TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	index := make(map[TagCount]int) // keyed by tag and collection, Count left zero
+	for _, post := range s.posts {
+		for _, tag := range post.Tags {
+			index[TagCount{Tag: tag, Collection: post.Collection}]++
+		}
+	}
+	counts := make([]TagCount, 0, len(index))
+	for key, n := range index {
+		key.Count = n
+		counts = append(counts, key)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Tag != counts[j].Tag {
+			return counts[i].Tag < counts[j].Tag
+		}
+		return counts[i].Collection < counts[j].Collection
+	})
+	return counts, nil
+}
+
+// GetPostsByTag returns all posts carrying the tag, sorted by title
+func (s *MemoryStore) GetPostsByTag(tag string) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tag = NormalizeTag(tag)
+	var posts []Post
+	for _, post := range s.posts {
+		for _, t := range post.Tags {
+			if t == tag {
+				posts = append(posts, post)
+				break
+			}
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Title < posts[j].Title
+	})
+	return posts, nil
+}
+
+// GetBacklinks returns the posts linking to the given post, sorted by title
+func (s *MemoryStore) GetBacklinks(collectionName string, url string) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var posts []Post
+	for _, post := range s.posts {
+		for _, link := range post.Links {
+			if link.Collection == collectionName && link.URL == url {
+				posts = append(posts, post)
+				break
+			}
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Title < posts[j].Title
+	})
+	return posts, nil
+}
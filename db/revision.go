@@ -0,0 +1,85 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Post sources recorded with every revision
+const (
+	SourceSync   = "sync"   // filesystem sync from SYNC_DIR
+	SourceUpload = "upload" // web/API upload
+	SourceAPI    = "add"    // legacy /add endpoint
+)
+
+// Revision is a snapshot of a post taken whenever its content changes
+type Revision struct {
+	Collection string    `bson:"collection" json:"collection"`
+	URL        string    `bson:"url" json:"url"`
+	Number     int       `bson:"number" json:"number"`
+	Title      string    `bson:"title" json:"title"`
+	Body       string    `bson:"body" json:"body,omitempty"`
+	Hash       string    `bson:"hash" json:"hash"`
+	Source     string    `bson:"source" json:"source"`
+	CreatedAt  time.Time `bson:"createdat" json:"createdAt"`
+}
+
+// RevisionStore keeps post history
+type RevisionStore interface {
+	// GetRevisions returns all revisions of a post, oldest first
+	GetRevisions(collectionName string, url string) ([]Revision, error)
+}
+
+// ContentHash returns the SHA-256 of a post's title, body and front matter
+func ContentHash(post Post) string {
+	content := post.Title + "\x00" + post.Body
+	// Posts without front matter keep the hash they had before metadata existed
+	if meta := metadataJSON(post); meta != "" {
+		content += "\x00" + meta
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// metadataJSON encodes the front matter fields of a post, or "" if there are none
+func metadataJSON(post Post) string {
+	data, err := json.Marshal(struct {
+		Description string                 `json:"description,omitempty"`
+		Tags        []string               `json:"tags,omitempty"`
+		Authors     []string               `json:"authors,omitempty"`
+		Date        *time.Time             `json:"date,omitempty"`
+		Weight      int                    `json:"weight,omitempty"`
+		Draft       bool                   `json:"draft,omitempty"`
+		Aliases     []string               `json:"aliases,omitempty"`
+		Meta        map[string]interface{} `json:"meta,omitempty"`
+	}{post.Description, post.Tags, post.Authors, post.Date, post.Weight, post.Draft, post.Aliases, post.Meta})
+	if err != nil || string(data) == "{}" {
+		return ""
+	}
+	return string(data)
+}
+
+// nextRevision builds the revision to record for post given the latest existing one.
+// It returns false when the content is unchanged and nothing should be recorded.
+func nextRevision(post Post, latest *Revision) (Revision, bool) {
+	hash := ContentHash(post)
+	number := 1
+	if latest != nil {
+		if latest.Hash == hash {
+			return Revision{}, false
+		}
+		number = latest.Number + 1
+	}
+	return Revision{
+		Collection: post.Collection,
+		URL:        post.URL,
+		Number:     number,
+		Title:      post.Title,
+		Body:       post.Body,
+		Hash:       hash,
+		Source:     post.Source,
+		CreatedAt:  time.Now().UTC(),
+	}, true
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned when a lookup matches nothing
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when inserting a post whose collection and url are taken
+var ErrExists = errors.New("post already exists")
+
+// Store is the storage backend used by the server and the sync code
+type Store interface {
+	// GetPosts returns all posts, newest first
+	GetPosts() ([]Post, error)
+	// GetPost returns the post identified by collection name and URL
+	GetPost(collectionName string, url string) (Post, error)
+	// GetPostsByURL returns the posts with the given url in any collection
+	GetPostsByURL(url string) ([]Post, error)
+	// GetIndexPost returns the index post for a collection (if exists)
+	GetIndexPost(collectionName string) (Post, error)
+	// InsertPost stores a new post, or returns ErrExists if collection+url is taken
+	InsertPost(post Post) error
+	// UpsertPost updates existing post or inserts new one based on collection+url.
+	// Inserts and upserts record a revision whenever the content changes.
+	UpsertPost(post Post) error
+	// GetCollections returns list of unique collection names
+	GetCollections() ([]string, error)
+	// GetPostsByCollection returns all posts in a collection sorted by weight, then title
+	GetPostsByCollection(collectionName string) ([]Post, error)
+	// DeleteCollection removes all posts from a collection
+	DeleteCollection(collectionName string) error
+	// DeletePostByPath deletes the post identified by collection name and URL
+	DeletePostByPath(collectionName string, url string) error
+	// RenameCollection updates the collection name for all posts
+	RenameCollection(oldName, newName string) error
+
+	TokenStore
+	CollectionStore
+	RevisionStore
+	TagStore
+	LinkStore
+}
+
+// OpenStore creates the store selected by the STORE environment variable.
+// Supported values: "mongo" (default), "memory" and "file" (in-memory store
+// persisted to STORE_PATH).
+func OpenStore() (Store, error) {
+	backend := strings.ToLower(os.Getenv("STORE"))
+	switch backend {
+	case "", "mongo":
+		return ConnectToDB()
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		path := os.Getenv("STORE_PATH")
+		if path == "" {
+			path = "./data/store.json"
+		}
+		return NewFileStore(path)
+	default:
+		return nil, fmt.Errorf("unknown STORE backend: %s", backend)
+	}
+}
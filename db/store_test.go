@@ -0,0 +1,122 @@
+package db_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/db/dbtest"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// samplePost sets every Post field, so a store that drops one fails the comparison
+func samplePost(collection string) db.Post {
+	// BSON keeps milliseconds in UTC
+	date := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	return db.Post{
+		Title:        "Guide",
+		Body:         "# Guide\n\nSee [setup](setup).",
+		URL:          "guide",
+		Collection:   collection,
+		IsIndex:      true,
+		Source:       "sync",
+		Description:  "How to get started",
+		Tags:         []string{"intro", "setup"},
+		Authors:      []string{"Ann"},
+		Date:         &date,
+		Weight:       3,
+		Draft:        true,
+		Aliases:      []string{"start"},
+		Meta:         map[string]interface{}{"layout": "wide"},
+		MissingFiles: []string{"diagrams/flow.puml"},
+		Links:        []db.PostRef{{Collection: collection, URL: "setup"}},
+	}
+}
+
+func TestUpsertPostStoresEveryField(t *testing.T) {
+	for name, store := range dbtest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			collection := dbtest.Collection("upsert")
+			defer store.DeleteCollection(collection)
+
+			// The second upsert replaces the first, including fields the first lacked
+			first := db.Post{Title: "Old", Body: "old", URL: "guide", Collection: collection}
+			if err := store.UpsertPost(first); err != nil {
+				t.Fatal(err)
+			}
+			want := samplePost(collection)
+			if err := store.UpsertPost(want); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.GetPost(collection, want.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("stored post differs\n got: %+v\nwant: %+v", got, want)
+			}
+			if db.ContentHash(got) != db.ContentHash(want) {
+				t.Errorf("content hash changed after storing")
+			}
+		})
+	}
+}
+
+func TestPostBSONRoundTrip(t *testing.T) {
+	// MongoStore.UpsertPost stores the post document as BSON marshals it
+	want := samplePost("docs")
+	data, err := bson.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got db.Post
+	if err := bson.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("post changed through BSON\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestConcurrentWritesNumberRevisions(t *testing.T) {
+	const writers = 8
+	for name, store := range dbtest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			collection := dbtest.Collection("revisions")
+			defer store.DeleteCollection(collection)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, writers)
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- store.UpsertPost(db.Post{Collection: collection, URL: "guide", Title: "Guide", Body: fmt.Sprintf("edit %d", i)})
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+
+			revisions, err := store.GetRevisions(collection, "guide")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revisions) != writers {
+				t.Fatalf("recorded %d revisions, want %d", len(revisions), writers)
+			}
+			for i, rev := range revisions {
+				if rev.Number != i+1 {
+					t.Errorf("revision %d has number %d", i+1, rev.Number)
+				}
+			}
+		})
+	}
+}
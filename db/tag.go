@@ -0,0 +1,23 @@
+package db
+
+import "strings"
+
+// TagCount is the number of posts carrying a tag within one collection
+type TagCount struct {
+	Tag        string `bson:"tag" json:"tag"`
+	Collection string `bson:"collection" json:"collection"`
+	Count      int    `bson:"count" json:"count"`
+}
+
+// TagStore answers taxonomy queries over post tags
+type TagStore interface {
+	// GetTagCounts returns post counts per tag and collection, sorted by tag
+	GetTagCounts() ([]TagCount, error)
+	// GetPostsByTag returns all posts carrying the tag, sorted by title
+	GetPostsByTag(tag string) ([]Post, error)
+}
+
+// NormalizeTag returns the canonical form tags are stored and looked up in
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), " "))
+}
@@ -0,0 +1,76 @@
+package db_test
+
+import (
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/db/dbtest"
+)
+
+// tagCounts returns the counts of collection's tags
+func tagCounts(t *testing.T, store db.Store, collection string) map[string]int {
+	t.Helper()
+	counts, err := store.GetTagCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]int)
+	for _, c := range counts {
+		if c.Collection == collection {
+			found[c.Tag] = c.Count
+		}
+	}
+	return found
+}
+
+// taggedURLs returns the urls of collection's posts carrying tag, in order
+func taggedURLs(t *testing.T, store db.Store, collection, tag string) []string {
+	t.Helper()
+	posts, err := store.GetPostsByTag(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, post := range posts {
+		if post.Collection == collection {
+			urls = append(urls, post.URL)
+		}
+	}
+	return urls
+}
+
+func TestTagsFollowPosts(t *testing.T) {
+	for name, store := range dbtest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			collection := dbtest.Collection("tags")
+			defer store.DeleteCollection(collection)
+			upsert := func(url, title string, tags ...string) {
+				post := db.Post{Collection: collection, URL: url, Title: title, Body: title, Tags: tags}
+				if err := store.UpsertPost(post); err != nil {
+					t.Fatal(err)
+				}
+			}
+			upsert("login", "Login", "security", "onboarding")
+			upsert("audit", "Audit", "security")
+
+			counts := tagCounts(t, store, collection)
+			if counts["security"] != 2 || counts["onboarding"] != 1 || len(counts) != 2 {
+				t.Errorf("tag counts = %v, want security 2 and onboarding 1", counts)
+			}
+			if urls := taggedURLs(t, store, collection, "security"); len(urls) != 2 || urls[0] != "audit" || urls[1] != "login" {
+				t.Errorf("posts tagged security = %v, want audit and login by title", urls)
+			}
+
+			// Retagging moves the post between tags
+			upsert("login", "Login", "onboarding")
+			upsert("audit", "Audit")
+			counts = tagCounts(t, store, collection)
+			if counts["security"] != 0 || counts["onboarding"] != 1 {
+				t.Errorf("tag counts after retagging = %v, want onboarding 1", counts)
+			}
+			if urls := taggedURLs(t, store, collection, "security"); len(urls) != 0 {
+				t.Errorf("posts still tagged security: %v", urls)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package db
+
+import "time"
+
+// Token is an API token. Only the SHA-256 hash of the secret is stored.
+type Token struct {
+	ID        string    `bson:"id" json:"id"`
+	Name      string    `bson:"name" json:"name"`
+	Hash      string    `bson:"hash" json:"hash"`
+	Scope     string    `bson:"scope" json:"scope"`
+	Groups    []string  `bson:"groups" json:"groups,omitempty"`
+	CreatedAt time.Time `bson:"createdat" json:"createdAt"`
+	Revoked   bool      `bson:"revoked" json:"revoked"`
+}
+
+// TokenStore keeps API tokens
+type TokenStore interface {
+	// CreateToken stores a new token
+	CreateToken(token Token) error
+	// GetTokenByHash returns the token with the given secret hash
+	GetTokenByHash(hash string) (Token, error)
+	// ListTokens returns all tokens, including revoked ones
+	ListTokens() ([]Token, error)
+	// RevokeToken marks the token with the given ID as revoked
+	RevokeToken(id string) error
+}
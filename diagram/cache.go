@@ -0,0 +1,320 @@
+package diagram
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Cache modes accepted by DIAGRAM_CACHE
+const (
+	CacheDisk   = "disk"
+	CacheMemory = "memory"
+	CacheOff    = "off"
+)
+
+// ErrUnknownDiagram is returned for a hash whose source the cache has never seen
+var ErrUnknownDiagram = errors.New("unknown diagram")
+
+// hashPattern matches the hex SHA-256 names diagrams are stored under
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Default cache limits
+const (
+	DefaultMaxBytes   = 256 << 20 // 256MB of rendered images
+	DefaultMaxSources = 10000
+)
+
+// CacheOptions limits what a Cache keeps. Zero values use the defaults.
+type CacheOptions struct {
+	// MaxBytes bounds the rendered images kept on disk or in memory; the
+	// least recently used are dropped and rendered again when requested
+	MaxBytes int64
+	// MaxSources bounds the diagram sources held in memory. On disk sources
+	// are kept and only reloaded; in memory an evicted source's diagram is
+	// unknown until its post is imported again.
+	MaxSources int
+}
+
+// Cache stores rendered diagrams under the hash of their language and
+// source, so a diagram is rendered once and keeps loading while its renderer
+// is down
+type Cache struct {
+	dir    string // "" keeps everything in memory
+	render func(language, source, format string) ([]byte, error)
+
+	mu       sync.Mutex
+	sources  *lru // hash -> entry
+	images   *lru // hash.format -> []byte in memory, nil for files on disk
+	inflight map[string]*pending
+}
+
+// entry is a diagram source recorded by Add
+type entry struct {
+	language string
+	source   string
+}
+
+// pending is a render in progress that other requests for the same image wait on
+type pending struct {
+	done  chan struct{}
+	image []byte
+	err   error
+}
+
+// NewCache creates a cache storing sources and images in dir ("" for memory only)
+func NewCache(dir string, options CacheOptions, render func(language, source, format string) ([]byte, error)) (*Cache, error) {
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = DefaultMaxBytes
+	}
+	if options.MaxSources <= 0 {
+		options.MaxSources = DefaultMaxSources
+	}
+	c := &Cache{
+		dir:      dir,
+		render:   render,
+		sources:  newLRU(int64(options.MaxSources), nil),
+		images:   newLRU(options.MaxBytes, nil),
+		inflight: make(map[string]*pending),
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		c.images.evict = func(name string) {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to evict cached diagram %s: %v", name, err)
+			}
+		}
+		if err := c.loadImages(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// loadImages tracks the images a previous run left in dir, oldest first, and
+// evicts what exceeds the size limit
+func (c *Cache) loadImages() error {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, f := range files {
+		dot := strings.LastIndex(f.Name(), ".")
+		if _, ok := Formats[f.Name()[dot+1:]]; ok && dot > 0 && !f.IsDir() {
+			c.images.add(f.Name(), nil, f.Size())
+		}
+	}
+	return nil
+}
+
+// Hash returns the content address of a diagram
+func Hash(language string, source string) string {
+	sum := sha256.Sum256([]byte(language + "\n" + source))
+	return hex.EncodeToString(sum[:])
+}
+
+// Add records a diagram source and returns its hash
+func (c *Cache) Add(language string, source string) string {
+	hash := Hash(language, source)
+	c.mu.Lock()
+	_, known := c.sources.get(hash)
+	c.sources.add(hash, entry{language: language, source: source}, 1)
+	c.mu.Unlock()
+	if !known && c.dir != "" {
+		path := filepath.Join(c.dir, hash+".src")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := writeFile(path, []byte(language+"\n"+source)); err != nil {
+				log.Printf("Failed to store diagram source %s: %v", hash, err)
+			}
+		}
+	}
+	return hash
+}
+
+// Image returns a rendered diagram, rendering it on the first request.
+// Concurrent requests for the same image share one render.
+func (c *Cache) Image(hash string, format string) ([]byte, error) {
+	if !hashPattern.MatchString(hash) {
+		return nil, ErrUnknownDiagram
+	}
+	if _, ok := Formats[format]; !ok {
+		return nil, fmt.Errorf("unsupported diagram format: %s", format)
+	}
+	name := hash + "." + format
+
+	c.mu.Lock()
+	if image, ok := c.images.get(name); ok && c.dir == "" {
+		c.mu.Unlock()
+		return image.([]byte), nil
+	}
+	if p, ok := c.inflight[name]; ok {
+		c.mu.Unlock()
+		<-p.done
+		return p.image, p.err
+	}
+	p := &pending{done: make(chan struct{})}
+	c.inflight[name] = p
+	c.mu.Unlock()
+
+	p.image, p.err = c.load(hash, format)
+
+	c.mu.Lock()
+	delete(c.inflight, name)
+	if p.err == nil {
+		if c.dir == "" {
+			c.images.add(name, p.image, int64(len(p.image)))
+		} else {
+			c.images.add(name, nil, int64(len(p.image)))
+		}
+	}
+	c.mu.Unlock()
+	close(p.done)
+	return p.image, p.err
+}
+
+// load reads a rendered image from disk or renders and stores it
+func (c *Cache) load(hash string, format string) ([]byte, error) {
+	path := filepath.Join(c.dir, hash+"."+format)
+	if c.dir != "" {
+		if image, err := ioutil.ReadFile(path); err == nil {
+			return image, nil
+		}
+	}
+	e, err := c.source(hash)
+	if err != nil {
+		return nil, err
+	}
+	image, err := c.render(e.language, e.source, format)
+	if err != nil {
+		return nil, err
+	}
+	if c.dir != "" {
+		if err := writeFile(path, image); err != nil {
+			log.Printf("Failed to cache diagram %s.%s: %v", hash, format, err)
+		}
+	}
+	return image, nil
+}
+
+// Has reports whether a diagram was added to the cache
+func (c *Cache) Has(hash string) bool {
+	if !hashPattern.MatchString(hash) {
+		return false
+	}
+	_, err := c.source(hash)
+	return err == nil
+}
+
+// source returns a recorded diagram source
+func (c *Cache) source(hash string) (entry, error) {
+	c.mu.Lock()
+	e, ok := c.sources.get(hash)
+	c.mu.Unlock()
+	if ok {
+		return e.(entry), nil
+	}
+	if c.dir != "" {
+		if data, err := ioutil.ReadFile(filepath.Join(c.dir, hash+".src")); err == nil {
+			parts := strings.SplitN(string(data), "\n", 2)
+			if len(parts) == 2 {
+				e := entry{language: parts[0], source: parts[1]}
+				c.mu.Lock()
+				c.sources.add(hash, e, 1)
+				c.mu.Unlock()
+				return e, nil
+			}
+		}
+	}
+	return entry{}, ErrUnknownDiagram
+}
+
+// writeFile writes data through a temporary file so readers never see a partial image
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lru keeps entries by recency and evicts the least recently used once their
+// total size exceeds max. The most recent entry is kept even if it alone is
+// larger. Callers synchronize access.
+type lru struct {
+	max   int64
+	size  int64
+	order *list.List // of *lruItem, most recent first
+	items map[string]*list.Element
+	evict func(key string) // called for evicted entries, may be nil
+}
+
+// lruItem is an entry of an lru
+type lruItem struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// newLRU creates an lru holding up to max in total size
+func newLRU(max int64, evict func(key string)) *lru {
+	return &lru{max: max, order: list.New(), items: make(map[string]*list.Element), evict: evict}
+}
+
+// get returns an entry and marks it most recently used
+func (l *lru) get(key string) (interface{}, bool) {
+	e, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*lruItem).value, true
+}
+
+// add inserts or replaces an entry and evicts entries beyond the limit
+func (l *lru) add(key string, value interface{}, size int64) {
+	if e, ok := l.items[key]; ok {
+		item := e.Value.(*lruItem)
+		l.size += size - item.size
+		item.value, item.size = value, size
+		l.order.MoveToFront(e)
+	} else {
+		l.items[key] = l.order.PushFront(&lruItem{key: key, value: value, size: size})
+		l.size += size
+	}
+	for l.size > l.max && l.order.Len() > 1 {
+		item := l.order.Remove(l.order.Back()).(*lruItem)
+		delete(l.items, item.key)
+		l.size -= item.size
+		if l.evict != nil {
+			l.evict(item.key)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package diagram
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRender renders a diagram as 100 bytes and counts renders
+func fakeRender(renders *int) func(language, source, format string) ([]byte, error) {
+	return func(language, source, format string) ([]byte, error) {
+		*renders++
+		return []byte(strings.Repeat("x", 100)), nil
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsedImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagram-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, dir := range map[string]string{"memory": "", "disk": dir} {
+		t.Run(name, func(t *testing.T) {
+			renders := 0
+			c, err := NewCache(dir, CacheOptions{MaxBytes: 250}, fakeRender(&renders))
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, b, d := c.Add("dot", "a"), c.Add("dot", "b"), c.Add("dot", "d")
+			for _, hash := range []string{a, b, a, d} { // d evicts b, the least recently used
+				if _, err := c.Image(hash, FormatPNG); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if renders != 3 {
+				t.Errorf("rendered %d times, want 3", renders)
+			}
+			c.Image(a, FormatPNG)
+			c.Image(d, FormatPNG)
+			if renders != 3 {
+				t.Errorf("cached images rendered again (%d renders)", renders)
+			}
+			c.Image(b, FormatPNG)
+			if renders != 4 {
+				t.Errorf("evicted image not rendered again (%d renders)", renders)
+			}
+			if dir != "" {
+				if _, err := os.Stat(filepath.Join(dir, a+"."+FormatPNG)); !os.IsNotExist(err) {
+					t.Errorf("evicted image file still on disk")
+				}
+			}
+		})
+	}
+}
+
+func TestCacheHasOnlyAddedDiagrams(t *testing.T) {
+	renders := 0
+	c, err := NewCache("", CacheOptions{MaxSources: 2}, fakeRender(&renders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := c.Add("dot", "a")
+	if !c.Has(a) {
+		t.Errorf("Has(added diagram) = false")
+	}
+	if c.Has(Hash("dot", "never added")) || c.Has("not-a-hash") {
+		t.Errorf("Has(unknown diagram) = true")
+	}
+	c.Add("dot", "b")
+	c.Add("dot", "c")
+	if c.Has(a) {
+		t.Errorf("source beyond MaxSources was kept")
+	}
+	if _, err := c.Image(a, FormatPNG); err != ErrUnknownDiagram {
+		t.Errorf("Image(evicted source) = %v, want ErrUnknownDiagram", err)
+	}
+}
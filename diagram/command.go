@@ -0,0 +1,260 @@
+package diagram
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds a single render, including time spent queued
+const DefaultTimeout = 30 * time.Second
+
+// ErrTimeout is returned when a diagram does not render within the timeout
+var ErrTimeout = errors.New("diagram render timed out")
+
+// Pool runs renders on a fixed number of workers. A render waits for a free
+// worker and runs for at most Timeout in total.
+type Pool struct {
+	Workers int
+	Timeout time.Duration
+
+	jobs chan job
+}
+
+// job is one render queued for the workers
+type job struct {
+	ctx    context.Context
+	run    func(ctx context.Context) ([]byte, error)
+	result chan result
+}
+
+// result is the outcome of a job
+type result struct {
+	image []byte
+	err   error
+}
+
+// NewPool starts workers
+func NewPool(workers int, timeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{Workers: workers, Timeout: timeout, jobs: make(chan job)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Run queues run for a worker and waits for its result
+func (p *Pool) Run(run func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+	j := job{ctx: ctx, run: run, result: make(chan result, 1)}
+	select {
+	case p.jobs <- j:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: all %d workers busy", ErrTimeout, p.Workers)
+	}
+	res := <-j.result
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%w after %v", ErrTimeout, p.Timeout)
+	}
+	return res.image, res.err
+}
+
+// work runs queued jobs until the process exits
+func (p *Pool) work() {
+	for j := range p.jobs {
+		image, err := j.run(j.ctx)
+		j.result <- result{image: image, err: err}
+	}
+}
+
+// Exec runs a command with stdin, returning its stdout
+func Exec(ctx context.Context, stdin string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", filepath.Base(name), err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("%s produced no output: %s", filepath.Base(name), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Command renders diagrams with a local program, e.g. Graphviz's dot
+type Command struct {
+	languages  []string
+	extensions []string
+	formats    []string
+	pool       *Pool
+	// render runs the program for one diagram
+	render func(ctx context.Context, source, format string) ([]byte, error)
+}
+
+// Languages ...
+func (c *Command) Languages() []string { return c.languages }
+
+// Extensions ...
+func (c *Command) Extensions() []string { return c.extensions }
+
+// Formats ...
+func (c *Command) Formats() []string { return c.formats }
+
+// Prepare ...
+func (c *Command) Prepare(source string) string { return source }
+
+// Render runs the program on a pool worker
+func (c *Command) Render(source string, format string) ([]byte, error) {
+	if !supports(c, format) {
+		return nil, fmt.Errorf("%s diagrams cannot be rendered as %s", c.languages[0], format)
+	}
+	return c.pool.Run(func(ctx context.Context) ([]byte, error) {
+		return c.render(ctx, source, format)
+	})
+}
+
+// Graphviz renders ```dot blocks and .dot/.gv files with dot
+func Graphviz(dot string, pool *Pool) *Command {
+	return &Command{
+		languages:  []string{"dot", "graphviz"},
+		extensions: []string{".dot", ".gv"},
+		formats:    []string{FormatPNG, FormatSVG},
+		pool:       pool,
+		render: func(ctx context.Context, source, format string) ([]byte, error) {
+			return Exec(ctx, source, dot, "-T"+format)
+		},
+	}
+}
+
+// Mermaid renders ```mermaid blocks and .mmd files with the mermaid CLI (mmdc)
+func Mermaid(mmdc string, pool *Pool) *Command {
+	return &Command{
+		languages:  []string{"mermaid"},
+		extensions: []string{".mmd", ".mermaid"},
+		formats:    []string{FormatSVG, FormatPNG},
+		pool:       pool,
+		render: func(ctx context.Context, source, format string) ([]byte, error) {
+			return throughFiles(ctx, source, ".mmd", format, func(in, out string) []string {
+				return []string{mmdc, "--quiet", "-i", in, "-o", out}
+			})
+		},
+	}
+}
+
+// D2 renders ```d2 blocks and .d2 files with the d2 CLI
+func D2(d2 string, pool *Pool) *Command {
+	return &Command{
+		languages:  []string{"d2"},
+		extensions: []string{".d2"},
+		// PNG output needs a browser d2 downloads on first use; SVG works offline
+		formats: []string{FormatSVG},
+		pool:    pool,
+		render: func(ctx context.Context, source, format string) ([]byte, error) {
+			return throughFiles(ctx, source, ".d2", format, func(in, out string) []string {
+				return []string{d2, in, out}
+			})
+		},
+	}
+}
+
+// throughFiles runs a program that reads and writes files instead of pipes;
+// the output format follows the output file's extension
+func throughFiles(ctx context.Context, source, ext, format string, command func(in, out string) []string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "diagram-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "diagram"+ext)
+	out := filepath.Join(dir, "diagram."+format)
+	if err := ioutil.WriteFile(in, []byte(source), 0644); err != nil {
+		return nil, err
+	}
+	args := command(in, out)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stdout = &stderr
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", filepath.Base(args[0]), err, strings.TrimSpace(stderr.String()))
+	}
+	return ioutil.ReadFile(out)
+}
+
+// registerLocal makes RegisterLocal look for programs only once
+var registerLocal sync.Once
+
+// RegisterLocal registers the Graphviz, Mermaid and D2 renderers whose
+// programs are installed. GRAPHVIZ_DOT, MERMAID_CLI and D2_BIN name the
+// programs; DIAGRAM_WORKERS and DIAGRAM_TIMEOUT configure their shared pool.
+// Missing programs are reported once.
+func RegisterLocal() {
+	registerLocal.Do(lookupLocal)
+}
+
+// lookupLocal registers the renderers of the installed programs
+func lookupLocal() {
+	workers := runtime.NumCPU()
+	if v := os.Getenv("DIAGRAM_WORKERS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			workers = n
+		} else {
+			log.Printf("Invalid DIAGRAM_WORKERS %q, using %d", v, workers)
+		}
+	}
+	timeout := DefaultTimeout
+	if v := os.Getenv("DIAGRAM_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			timeout = d
+		} else {
+			log.Printf("Invalid DIAGRAM_TIMEOUT %q, using %v", v, timeout)
+		}
+	}
+
+	var pool *Pool
+	var missing []string
+	for _, local := range []struct {
+		env, program string
+		renderer     func(program string, pool *Pool) *Command
+	}{
+		{"GRAPHVIZ_DOT", "dot", Graphviz},
+		{"MERMAID_CLI", "mmdc", Mermaid},
+		{"D2_BIN", "d2", D2},
+	} {
+		program := os.Getenv(local.env)
+		if program == "" {
+			program = local.program
+		}
+		path, err := exec.LookPath(program)
+		if err != nil {
+			missing = append(missing, program)
+			continue
+		}
+		if pool == nil {
+			pool = NewPool(workers, timeout)
+		}
+		r := local.renderer(path, pool)
+		Register(r)
+		log.Printf("Diagram renderer: %s via %s", strings.Join(r.Languages(), "/"), path)
+	}
+	if len(missing) > 0 {
+		log.Printf("Diagram renderers not installed, their diagrams render as code: %s", strings.Join(missing, ", "))
+	}
+}
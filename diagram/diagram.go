@@ -0,0 +1,474 @@
+package diagram
+
+import (
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/beldmian/go-markdown-server/highlight"
+)
+
+// Output formats for diagrams in pages
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Formats maps the output formats diagrams are served in to their content types
+var Formats = map[string]string{
+	FormatPNG: "image/png",
+	FormatSVG: "image/svg+xml",
+}
+
+// DiagramRenderer turns the source of one diagram language into images
+type DiagramRenderer interface {
+	// Languages are the fence languages handled, e.g. "dot" and "graphviz"
+	Languages() []string
+	// Extensions are the file extensions referenced as ![x](file.ext), e.g. ".dot"
+	Extensions() []string
+	// Formats are the supported output formats, preferred first
+	Formats() []string
+	// Prepare normalizes source before it is hashed and rendered
+	Prepare(source string) string
+	// Render renders prepared source in one of Formats
+	Render(source string, format string) ([]byte, error)
+}
+
+// Linker is implemented by renderers that can link to a diagram without the
+// cache, e.g. on an external server; used when DIAGRAM_CACHE=off
+type Linker interface {
+	Link(source string, format string) string
+	// Unlink reverses Link; ok is false for links that are not its own
+	Unlink(url string) (source string, format string, ok bool)
+}
+
+// inlineFragment marks diagram images that InlineSVG embeds as <svg> markup.
+// Browsers ignore it when the image is shown through an <img> instead.
+const inlineFragment = "#inline"
+
+var (
+	mu          sync.RWMutex
+	byLanguage  = make(map[string]DiagramRenderer)
+	byExtension = make(map[string]DiagramRenderer)
+
+	cache         *Cache
+	cacheOnce     sync.Once
+	defaultFormat = FormatPNG
+	defaultInline bool
+)
+
+// codeBlock matches a fence with a language and optional attributes: ```dot {format=svg}
+var codeBlock = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]+)([^\\n`]*)\\n(.*?)\\n```")
+
+// fileReference matches an image reference: ![title](path)
+var fileReference = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+
+// inlineImage matches a markdown image marked for inlining: ![title](url#inline)
+var inlineImage = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)` + inlineFragment + `\)`)
+
+// svgComment matches comments renderers leave in their SVG output
+var svgComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+// svgProlog matches what precedes the <svg> element in a standalone SVG file
+var svgProlog = regexp.MustCompile(`(?s)^.*?(<svg[\s>])`)
+
+func init() {
+	outputFromEnv()
+}
+
+// openCache creates the cache selected by DIAGRAM_CACHE=disk|memory|off and
+// limited by DIAGRAM_CACHE_MB and DIAGRAM_CACHE_SOURCES; rendered diagrams
+// are served from /diagrams/
+func openCache() {
+	dir := ""
+	switch mode := strings.ToLower(os.Getenv("DIAGRAM_CACHE")); mode {
+	case "", CacheDisk:
+		dir = os.Getenv("DIAGRAM_CACHE_DIR")
+		if dir == "" {
+			dir = "diagram-cache"
+		}
+	case CacheMemory:
+	case CacheOff:
+		log.Printf("Diagram cache disabled")
+		return
+	default:
+		log.Printf("Unknown DIAGRAM_CACHE %q, using memory", mode)
+	}
+	var options CacheOptions
+	if v := os.Getenv("DIAGRAM_CACHE_MB"); v != "" {
+		if mb, err := strconv.Atoi(v); err == nil && mb > 0 {
+			options.MaxBytes = int64(mb) << 20
+		} else {
+			log.Printf("Invalid DIAGRAM_CACHE_MB %q, using %dMB", v, DefaultMaxBytes>>20)
+		}
+	}
+	if v := os.Getenv("DIAGRAM_CACHE_SOURCES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			options.MaxSources = n
+		} else {
+			log.Printf("Invalid DIAGRAM_CACHE_SOURCES %q, using %d", v, DefaultMaxSources)
+		}
+	}
+	c, err := NewCache(dir, options, render)
+	if err != nil {
+		log.Printf("Failed to create diagram cache in %s, using memory: %v", dir, err)
+		c, _ = NewCache("", options, render)
+	} else if dir != "" {
+		log.Printf("Diagram cache directory: %s", dir)
+	}
+	cache = c
+}
+
+// Register makes a renderer available for its fence languages and file extensions
+func Register(r DiagramRenderer) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, language := range r.Languages() {
+		byLanguage[strings.ToLower(language)] = r
+	}
+	for _, ext := range r.Extensions() {
+		byExtension[strings.ToLower(ext)] = r
+	}
+}
+
+// Lookup returns the renderer registered for a fence language
+func Lookup(language string) (DiagramRenderer, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	r, ok := byLanguage[strings.ToLower(language)]
+	return r, ok
+}
+
+// Languages returns the registered fence languages, sorted
+func Languages() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	languages := make([]string, 0, len(byLanguage))
+	for language := range byLanguage {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// lookupFile returns the renderer for a referenced file by its extension
+func lookupFile(file string) (DiagramRenderer, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	r, ok := byExtension[strings.ToLower(path.Ext(file))]
+	return r, ok
+}
+
+// DefaultCache returns the diagram cache, opening it on first use, or nil
+// when DIAGRAM_CACHE=off
+func DefaultCache() *Cache {
+	cacheOnce.Do(openCache)
+	return cache
+}
+
+// render renders a diagram for the cache with the renderer of its language
+func render(language string, source string, format string) ([]byte, error) {
+	r, ok := Lookup(language)
+	if !ok {
+		return nil, fmt.Errorf("no renderer for diagram language %q", language)
+	}
+	return r.Render(source, format)
+}
+
+// outputFromEnv reads DIAGRAM_FORMAT (png or svg) and DIAGRAM_INLINE, falling
+// back to PLANTUML_FORMAT and PLANTUML_INLINE
+func outputFromEnv() {
+	format := os.Getenv("DIAGRAM_FORMAT")
+	if format == "" {
+		format = os.Getenv("PLANTUML_FORMAT")
+	}
+	switch format = strings.ToLower(format); format {
+	case "":
+	case FormatPNG, FormatSVG:
+		defaultFormat = format
+	default:
+		log.Printf("Unknown DIAGRAM_FORMAT %q, using %s", format, defaultFormat)
+	}
+	inline := os.Getenv("DIAGRAM_INLINE")
+	if inline == "" {
+		inline = os.Getenv("PLANTUML_INLINE")
+	}
+	if inline != "" {
+		on, err := strconv.ParseBool(inline)
+		if err != nil {
+			log.Printf("Invalid DIAGRAM_INLINE %q, diagrams are not inlined", inline)
+		}
+		defaultInline = on
+	}
+}
+
+// output returns the format of a diagram and whether to inline it, from fence
+// attributes such as {format=svg} or {inline=true} and the global defaults.
+// Only SVG is inlined, so inline=true implies format=svg. Formats the
+// renderer lacks fall back to its preferred one.
+func output(r DiagramRenderer, attrs map[string]string) (format string, inline bool) {
+	format, inline = defaultFormat, defaultInline
+	if v, ok := attrs["format"]; ok {
+		switch v = strings.ToLower(v); v {
+		case FormatPNG, FormatSVG:
+			format = v
+			inline = inline && format == FormatSVG
+		default:
+			log.Printf("Unknown diagram format %q, using %s", v, format)
+		}
+	}
+	if v, ok := attrs["inline"]; ok {
+		inline, _ = strconv.ParseBool(v)
+		if inline {
+			format = FormatSVG
+		}
+	}
+	if !supports(r, format) {
+		format = r.Formats()[0]
+	}
+	return format, inline && format == FormatSVG
+}
+
+// supports reports whether r renders format
+func supports(r DiagramRenderer, format string) bool {
+	for _, f := range r.Formats() {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns the URL of a prepared diagram: its content address in the
+// cache, or the renderer's own link when the cache is off
+func URL(r DiagramRenderer, source string, format string) string {
+	if cache := DefaultCache(); cache != nil {
+		return fmt.Sprintf("/diagrams/%s.%s", cache.Add(r.Languages()[0], source), format)
+	}
+	if linker, ok := r.(Linker); ok {
+		return linker.Link(source, format)
+	}
+	return ""
+}
+
+// linkable reports whether URL can link to r's diagrams; without the cache
+// only Linker renderers can
+func linkable(r DiagramRenderer) bool {
+	_, ok := r.(Linker)
+	return DefaultCache() != nil || ok
+}
+
+// Image renders a prepared diagram, through the cache when there is one
+func Image(r DiagramRenderer, source string, format string) ([]byte, error) {
+	if cache := DefaultCache(); cache != nil {
+		return cache.Image(cache.Add(r.Languages()[0], source), format)
+	}
+	return r.Render(source, format)
+}
+
+// Embed returns the markdown image for a diagram, with the output picked by
+// fence attributes (nil for the defaults)
+func Embed(r DiagramRenderer, title string, source string, attrs map[string]string) string {
+	format, inline := output(r, attrs)
+	url := URL(r, r.Prepare(source), format)
+	if inline {
+		url += inlineFragment
+	}
+	return fmt.Sprintf("![%s](%s)", title, url)
+}
+
+// Check renders a diagram in the format its attributes ask for
+func Check(r DiagramRenderer, source string, attrs map[string]string) error {
+	format, _ := output(r, attrs)
+	_, err := Image(r, r.Prepare(source), format)
+	return err
+}
+
+// Process replaces fenced blocks of every registered diagram language, and
+// image references to diagram files, with images. Files resolve relative to
+// baseDir (the collection's folder under content/); a file that cannot be
+// read becomes a reference to a .png next to it.
+func Process(markdown string, baseDir string) string {
+	result := codeBlock.ReplaceAllStringFunc(markdown, func(match string) string {
+		m := codeBlock.FindStringSubmatch(match)
+		r, ok := Lookup(m[1])
+		if !ok || !linkable(r) {
+			// Other fences are kept whole so diagrams shown as code inside them stay code
+			return match
+		}
+		attrs := highlight.ParseFence(m[1] + " " + m[2]).Attrs
+		return Embed(r, title(r), strings.TrimSpace(m[3]), attrs)
+	})
+
+	return fileReference.ReplaceAllStringFunc(result, func(match string) string {
+		m := fileReference.FindStringSubmatch(match)
+		r, ok := lookupFile(m[2])
+		if !ok || !linkable(r) {
+			return match
+		}
+		source, err := readFile(m[2], baseDir)
+		if err != nil {
+			pngPath := strings.TrimSuffix(m[2], path.Ext(m[2])) + ".png"
+			return fmt.Sprintf("![%s](%s)", m[1], pngPath)
+		}
+		return Embed(r, m[1], source, nil)
+	})
+}
+
+// MissingFiles returns the diagram file references in markdown that cannot be read
+func MissingFiles(markdown string, baseDir string) []string {
+	var missing []string
+	for _, m := range fileReference.FindAllStringSubmatch(markdown, -1) {
+		if _, ok := lookupFile(m[2]); !ok {
+			continue
+		}
+		if _, err := readFile(m[2], baseDir); err != nil {
+			missing = append(missing, m[2])
+		}
+	}
+	return missing
+}
+
+// title is the alt text of diagrams from fenced blocks
+func title(r DiagramRenderer) string {
+	switch language := r.Languages()[0]; language {
+	case "plantuml":
+		return "PlantUML Diagram"
+	default:
+		return strings.Title(language) + " Diagram"
+	}
+}
+
+// ContentDir is the directory diagram file references are resolved in
+// (SYNC_DIR). Files outside it are never read.
+var ContentDir = "content"
+
+// readFile reads a diagram file below ContentDir, searching relative to baseDir
+func readFile(file string, baseDir string) (string, error) {
+	root, err := filepath.Abs(ContentDir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	// Build candidate paths based on baseDir
+	candidates := []string{}
+
+	// If baseDir provided, prioritize paths relative to it
+	if baseDir != "" {
+		// Direct: baseDir/path
+		candidates = append(candidates, filepath.Join(root, baseDir, file))
+		// With diagrams: baseDir/diagrams/basename
+		candidates = append(candidates, filepath.Join(root, baseDir, "diagrams", filepath.Base(file)))
+	}
+
+	// Fallback: direct path from content root
+	candidates = append(candidates, filepath.Join(root, file))
+
+	for _, fullPath := range candidates {
+		// Symlinks are followed before the check, so they cannot point outside either
+		resolved, err := filepath.EvalSymlinks(fullPath)
+		if err != nil || !within(root, resolved) {
+			continue
+		}
+		content, err := ioutil.ReadFile(resolved)
+		if err != nil {
+			continue
+		}
+		source := string(content)
+
+		// If the file wraps its source in a ``` fence, extract the content
+		if strings.HasPrefix(source, "```") {
+			lines := strings.Split(strings.TrimRight(source, "\n"), "\n")
+			if len(lines) > 2 {
+				// Remove first line (```plantuml) and last line (```)
+				source = strings.Join(lines[1:len(lines)-1], "\n")
+			}
+		}
+
+		return source, nil
+	}
+
+	return "", fmt.Errorf("diagram file not found: %s", file)
+}
+
+// within reports whether path is root or below it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// InlineSVG replaces diagram images marked for inlining with their SVG
+// markup, so links and tooltips in the diagram work inside the page. Images
+// that cannot be rendered stay images. Callers should only inline where the
+// HTML sanitizer keeps SVG.
+func InlineSVG(markdown string) string {
+	return inlineImage.ReplaceAllStringFunc(markdown, func(match string) string {
+		m := inlineImage.FindStringSubmatch(match)
+		svg, err := svgFor(m[2])
+		if err != nil {
+			log.Printf("Failed to inline diagram %s: %v", m[2], err)
+			return match
+		}
+		return "\n\n<div class=\"diagram\" title=\"" + html.EscapeString(m[1]) + "\">" + svg + "</div>\n\n"
+	})
+}
+
+// svgFor returns the SVG behind a diagram URL from URL
+func svgFor(url string) (string, error) {
+	var image []byte
+	var err error
+	if name := strings.TrimPrefix(url, "/diagrams/"); name != url && DefaultCache() != nil {
+		image, err = DefaultCache().Image(strings.TrimSuffix(name, "."+FormatSVG), FormatSVG)
+	} else {
+		image, err = unlink(url)
+	}
+	if err != nil {
+		return "", err
+	}
+	return svgMarkup(string(image))
+}
+
+// unlink renders the SVG behind a link made by a Linker
+func unlink(url string) ([]byte, error) {
+	mu.RLock()
+	var linkers []DiagramRenderer
+	for _, r := range byLanguage {
+		if _, ok := r.(Linker); ok {
+			linkers = append(linkers, r)
+		}
+	}
+	mu.RUnlock()
+	for _, r := range linkers {
+		if source, format, ok := r.(Linker).Unlink(url); ok {
+			if format != FormatSVG {
+				return nil, fmt.Errorf("not an SVG diagram")
+			}
+			return r.Render(source, format)
+		}
+	}
+	return nil, fmt.Errorf("not a diagram link: %s", url)
+}
+
+// svgMarkup strips the XML prolog and comments and puts the SVG on one line,
+// so markdown renderers treat it as a single HTML block
+func svgMarkup(svg string) (string, error) {
+	m := svgProlog.FindStringSubmatchIndex(svg)
+	if m == nil {
+		return "", fmt.Errorf("no <svg> element in diagram")
+	}
+	svg = svg[m[2]:]
+	svg = svgComment.ReplaceAllString(svg, "")
+	return strings.Join(strings.Fields(svg), " "), nil
+}
@@ -0,0 +1,48 @@
+package diagram
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadFileStaysInContentDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diagram-files-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := filepath.Join(dir, "content")
+	if err := os.MkdirAll(filepath.Join(content, "Arch", "diagrams"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret.dot")
+	for path, data := range map[string]string{
+		filepath.Join(content, "Arch", "flow.puml"):            "A -> B",
+		filepath.Join(content, "Arch", "diagrams", "seq.puml"): "B -> C",
+		secret: "digraph { secret }",
+	} {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(content, "Arch", "link.dot")); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := ContentDir
+	ContentDir = content
+	defer func() { ContentDir = previous }()
+
+	for _, file := range []string{"flow.puml", "seq.puml", "Arch/flow.puml"} {
+		if _, err := readFile(file, "Arch"); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+	for _, file := range []string{"../../secret.dot", "../secret.dot", secret, "link.dot"} {
+		if source, err := readFile(file, "Arch"); err == nil {
+			t.Errorf("%s read from outside the content directory: %q", file, source)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Line operations
+const (
+	Equal  = " "
+	Insert = "+"
+	Delete = "-"
+)
+
+// Line is one line of a diff
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines computes a minimal line diff between a and b using Myers' algorithm
+func Lines(a, b string) []Line {
+	x := splitLines(a)
+	y := splitLines(b)
+	n, m := len(x), len(y)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[k+offset] holds the furthest x reached on diagonal k; trace keeps a copy per step
+	offset := max
+	v := make([]int, 2*max+2)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var i int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				i = v[k+1+offset]
+			} else {
+				i = v[k-1+offset] + 1
+			}
+			j := i - k
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			v[k+offset] = i
+			if i >= n && j >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script
+	var lines []Line
+	i, j := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		vd := trace[d]
+		k := i - j
+		var prevK int
+		if k == -d || (k != d && vd[k-1+offset] < vd[k+1+offset]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevI := vd[prevK+offset]
+		prevJ := prevI - prevK
+		for i > prevI && j > prevJ {
+			i--
+			j--
+			lines = append(lines, Line{Op: Equal, Text: x[i]})
+		}
+		if d > 0 {
+			if i == prevI {
+				j--
+				lines = append(lines, Line{Op: Insert, Text: y[j]})
+			} else {
+				i--
+				lines = append(lines, Line{Op: Delete, Text: x[i]})
+			}
+		}
+	}
+
+	for l, r := 0, len(lines)-1; l < r; l, r = l+1, r-1 {
+		lines[l], lines[r] = lines[r], lines[l]
+	}
+	return lines
+}
+
+// Unified renders a diff in unified format with the given number of context lines
+func Unified(fromName, toName string, lines []Line, context int) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	aLine, bLine := 1, 1
+	for start := 0; start < len(lines); {
+		// Find the next change
+		for start < len(lines) && lines[start].Op == Equal {
+			start++
+			aLine++
+			bLine++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// Extend the hunk until there are more than 2*context equal lines in a row
+		end := start
+		for end < len(lines) {
+			if lines[end].Op != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(lines) && lines[run].Op == Equal {
+				run++
+			}
+			if run == len(lines) || run-end > 2*context {
+				break
+			}
+			end = run
+		}
+
+		before := context
+		if before > start {
+			before = start
+		}
+		// Lines after end are equal up to the next hunk, which is more than 2*context away
+		after := context
+		if end+after > len(lines) {
+			after = len(lines) - end
+		}
+
+		hunk := lines[start-before : end+after]
+		aCount, bCount := 0, 0
+		for _, l := range hunk {
+			if l.Op != Insert {
+				aCount++
+			}
+			if l.Op != Delete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aLine-before, aCount, bLine-before, bCount)
+		for _, l := range hunk {
+			out.WriteString(l.Op + l.Text + "\n")
+		}
+
+		for _, l := range lines[start:end] {
+			if l.Op != Insert {
+				aLine++
+			}
+			if l.Op != Delete {
+				bLine++
+			}
+		}
+		start = end
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
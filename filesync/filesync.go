@@ -4,190 +4,317 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/beldmian/go-markdown-server/db"
-	"github.com/beldmian/go-markdown-server/plantuml"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/beldmian/go-markdown-server/ingest"
+)
+
+// RootCollection is the collection name (after Prefix) for files directly under RootDir
+const RootCollection = "root"
+
+// Actions reported in a Result
+const (
+	ActionAdded     = "added"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionDeleted   = "deleted"
+	ActionFailed    = "failed"
 )
 
 // SyncConfig holds configuration for file sync
 type SyncConfig struct {
-	RootDir    string
-	Collection *mongo.Collection
+	RootDir string
+	Store   db.Store
+	// Pipeline turns files into posts; nil uses ingest.Default()
+	Pipeline *ingest.Pipeline
+	// Prefix is prepended to collection names derived from directories (e.g. "content/")
+	Prefix string
+	// Collection, if set, puts every file under RootDir into this one collection
+	Collection string
+	// Prune deletes synced posts of the affected collections whose file no longer exists
+	Prune bool
+	// DryRun reports what would change without writing to the store
+	DryRun bool
+}
+
+// Result reports what happened (or would happen) to a single post
+type Result struct {
+	Path       string `json:"path,omitempty"`
+	Collection string `json:"collection"`
+	URL        string `json:"url"`
+	Action     string `json:"action"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SplitPath determines the collection and path of a file under rootDir.
+// Directories map to nested collections: "Arch/guides/setup.md" belongs to
+// prefix+"Arch/guides". relPath is relative to the top-level directory
+// ("guides/setup.md") so slugs stay unique within it. Files directly under
+// rootDir go to prefix+"root".
+func SplitPath(rootDir, prefix, filePath string) (collectionName string, relPath string, err error) {
+	rel, err := filepath.Rel(rootDir, filePath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", "", fmt.Errorf("invalid file path: %s", filePath)
+	}
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+	if len(parts) == 1 {
+		return prefix + RootCollection, parts[0], nil
+	}
+	return JoinCollection(prefix+parts[0], path.Dir(parts[1])), parts[1], nil
+}
+
+// JoinCollection returns the child collection of parent for a slash separated sub-directory
+func JoinCollection(parent, dir string) string {
+	if dir == "" || dir == "." {
+		return parent
+	}
+	return parent + "/" + dir
+}
+
+// InCollection reports whether name is collection or one of its descendants
+func InCollection(name, collection string) bool {
+	return name == collection || strings.HasPrefix(name, collection+"/")
 }
 
 // SyncAllFiles recursively scans directory and imports all .md files
-func SyncAllFiles(config SyncConfig) error {
-	fmt.Printf("Starting sync from directory: %s\n", config.RootDir)
-	
+func SyncAllFiles(config SyncConfig) ([]Result, error) {
 	// Check if directory exists
-	if _, err := os.Stat(config.RootDir); os.IsNotExist(err) {
-		return fmt.Errorf("directory does not exist: %s", config.RootDir)
+	if info, err := os.Stat(config.RootDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("directory does not exist: %s", config.RootDir)
 	}
-	
-	fileCount := 0
-	
+
+	pipeline := config.Pipeline
+	if pipeline == nil {
+		pipeline = ingest.Default()
+	}
+
+	var results []Result
+	existing := make(map[string]map[string]db.Post) // collection -> url -> stored post
+	synced := make(map[string]map[string]bool)      // collection -> url -> produced by a file
+	roots := make(map[string]bool)                  // top-level collections visited
+
 	err := filepath.Walk(config.RootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-		
+
 		// Only process .md files
-		if !strings.HasSuffix(strings.ToLower(info.Name()), ".md") {
+		if info.IsDir() || !ingest.IsMarkdown(info.Name()) {
 			return nil
 		}
-		
-		// Import the file
-		if err := importMarkdownFile(path, config.RootDir, config.Collection); err != nil {
-			fmt.Printf("Error importing %s: %v\n", path, err)
-			return nil // Continue with other files
+
+		result := importMarkdownFile(config, pipeline, path, existing)
+		roots[topCollection(config, path)] = true
+		if synced[result.Collection] == nil {
+			synced[result.Collection] = make(map[string]bool)
 		}
-		
-		fileCount++
-		fmt.Printf("✓ Imported: %s\n", path)
-		
+		synced[result.Collection][result.URL] = true
+		results = append(results, result)
 		return nil
 	})
-	
 	if err != nil {
-		return err
+		return results, err
 	}
-	
-	fmt.Printf("Sync complete! Imported %d files.\n", fileCount)
-	return nil
+
+	if config.Prune {
+		pruned, err := prune(config, roots, existing, synced)
+		results = append(results, pruned...)
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
 }
 
-// importMarkdownFile reads a markdown file and creates a post in the database
-func importMarkdownFile(filePath string, rootDir string, collection *mongo.Collection) error {
-	// Read file content
-	content, err := ioutil.ReadFile(filePath)
+// importMarkdownFile reads a markdown file and upserts the post unless nothing changed
+func importMarkdownFile(config SyncConfig, pipeline *ingest.Pipeline, filePath string, existing map[string]map[string]db.Post) Result {
+	collectionName, relPath, err := SplitPath(config.RootDir, config.Prefix, filePath)
+	// PlantUML includes resolve against the directory name without prefix
+	baseDir := strings.TrimPrefix(collectionName, config.Prefix)
+	if config.Collection != "" && err == nil {
+		rel, _ := filepath.Rel(config.RootDir, filePath)
+		relPath = filepath.ToSlash(rel)
+		collectionName = JoinCollection(config.Collection, path.Dir(relPath))
+		baseDir = filepath.Base(config.RootDir)
+	}
+	result := Result{Path: filePath, Collection: collectionName}
 	if err != nil {
-		return err
+		return result.Failed(err)
 	}
-	
-	// Get relative path from root
-	relPath, err := filepath.Rel(rootDir, filePath)
+
+	content, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		relPath = filepath.Base(filePath)
-	}
-	
-	// Determine collection name from directory structure
-	collectionName := extractCollectionName(relPath, rootDir)
-	
-	// Parse file for title and body
-	title, body, isIndex := parseMarkdownFile(string(content), filepath.Base(filePath))
-	
-	// Process PlantUML diagrams with collection-aware base directory
-	body = plantuml.ProcessPlantUMLWithBase(body, collectionName)
-	
-	// Create URL-friendly slug
-	url := createSlug(title, relPath)
-	
-	// Create post
-	post := db.Post{
-		Title:      title,
-		URL:        url,
-		Body:       body,
+		return result.Failed(err)
+	}
+
+	rel, _ := filepath.Rel(config.RootDir, filePath)
+	post, err := pipeline.Ingest(ingest.File{
+		Path:       relPath,
+		Data:       content,
+		Origin:     db.SourceSync,
 		Collection: collectionName,
-		IsIndex:    isIndex,
+		BaseDir:    baseDir,
+		Source:     filepath.ToSlash(rel),
+		Locate:     config.Locator(),
+	})
+	if err != nil {
+		return result.Failed(err)
+	}
+	result.URL = post.URL
+
+	posts, err := loadCollection(config.Store, collectionName, existing)
+	if err != nil {
+		return result.Failed(err)
 	}
-	
-	// Upsert (update or insert) into database
-	err = db.UpsertPost(collection, post, "124252")
-	return err
+	previous, found := posts[post.URL]
+	switch {
+	case !found:
+		result.Action = ActionAdded
+	case unchanged(previous, post):
+		result.Action = ActionUnchanged
+		return result
+	default:
+		result.Action = ActionUpdated
+	}
+
+	if !config.DryRun {
+		// Upsert (update or insert) into database
+		if err := config.Store.UpsertPost(post); err != nil {
+			return result.Failed(err)
+		}
+	}
+	return result
 }
 
-// extractCollectionName determines collection name from file path
-func extractCollectionName(relPath string, rootDir string) string {
-	// Get first directory in relative path
-	parts := strings.Split(filepath.ToSlash(relPath), "/")
-	
-	if len(parts) > 1 {
-		// Use first subdirectory as collection name
-		return parts[0]
-	}
-	
-	// If file is in root, use root directory name
-	return filepath.Base(rootDir)
+// Locator maps paths relative to RootDir the same way SyncAllFiles does, so
+// links between files resolve to the posts they are imported as
+func (config SyncConfig) Locator() ingest.Locator {
+	return func(source string) (string, string, bool) {
+		if config.Collection != "" {
+			return JoinCollection(config.Collection, path.Dir(source)), source, true
+		}
+		collectionName, relPath, err := SplitPath(config.RootDir, config.Prefix, filepath.Join(config.RootDir, filepath.FromSlash(source)))
+		return collectionName, relPath, err == nil
+	}
 }
 
-// parseMarkdownFile extracts title and body from markdown content
-func parseMarkdownFile(content string, filename string) (title string, body string, isIndex bool) {
-	body = content
-	title = strings.TrimSuffix(filename, ".md")
-	isIndex = false
-	
-	// Check if this is an index file
-	lowerFilename := strings.ToLower(filename)
-	if lowerFilename == "index.md" || lowerFilename == "readme.md" {
-		isIndex = true
-	}
-	
-	// Parse frontmatter if exists
-	if strings.HasPrefix(content, "---") {
-		parts := strings.SplitN(content, "---", 3)
-		if len(parts) >= 3 {
-			frontmatter := parts[1]
-			body = strings.TrimSpace(parts[2])
-			
-			// Extract title from frontmatter
-			for _, line := range strings.Split(frontmatter, "\n") {
-				line = strings.TrimSpace(line)
-				if strings.HasPrefix(line, "title:") {
-					title = strings.TrimSpace(strings.TrimPrefix(line, "title:"))
-					title = strings.Trim(title, "\"'")
-					break
-				}
-			}
+// unchanged reports whether storing post would not change previous. Besides
+// the content this covers what the import derives from the file's location.
+func unchanged(previous, post db.Post) bool {
+	if db.ContentHash(previous) != db.ContentHash(post) || previous.IsIndex != post.IsIndex {
+		return false
+	}
+	if len(previous.Links) != len(post.Links) || len(previous.MissingFiles) != len(post.MissingFiles) {
+		return false
+	}
+	for i := range post.Links {
+		if previous.Links[i] != post.Links[i] {
+			return false
+		}
+	}
+	for i := range post.MissingFiles {
+		if previous.MissingFiles[i] != post.MissingFiles[i] {
+			return false
 		}
 	}
-	
-	// If no title from frontmatter, try to extract from first H1
-	if title == strings.TrimSuffix(filename, ".md") {
-		lines := strings.Split(body, "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "# ") {
-				title = strings.TrimPrefix(line, "# ")
+	return true
+}
+
+// topCollection returns the top-level collection a file under RootDir belongs to
+func topCollection(config SyncConfig, filePath string) string {
+	if config.Collection != "" {
+		return config.Collection
+	}
+	rel, _ := filepath.Rel(config.RootDir, filePath)
+	parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
+	if len(parts) == 1 {
+		return config.Prefix + RootCollection
+	}
+	return config.Prefix + parts[0]
+}
+
+// prune removes synced posts below the visited top-level collections that no file produced
+func prune(config SyncConfig, roots map[string]bool, existing map[string]map[string]db.Post, synced map[string]map[string]bool) ([]Result, error) {
+	if config.Collection != "" {
+		// An empty directory still prunes the target collection
+		roots[config.Collection] = true
+	}
+	all, err := config.Store.GetCollections()
+	if err != nil {
+		return nil, err
+	}
+	var collections []string
+	for _, name := range all {
+		for root := range roots {
+			if InCollection(name, root) {
+				collections = append(collections, name)
 				break
 			}
 		}
 	}
-	
-	return title, body, isIndex
+	sort.Strings(collections)
+
+	var results []Result
+	for _, name := range collections {
+		posts, err := loadCollection(config.Store, name, existing)
+		if err != nil {
+			return results, err
+		}
+		var urls []string
+		for url := range posts {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+		for _, url := range urls {
+			// Only posts that came from the filesystem; uploads and /add posts stay
+			if synced[name][url] || posts[url].Source != db.SourceSync {
+				continue
+			}
+			result := Result{Collection: name, URL: url, Action: ActionDeleted}
+			if !config.DryRun {
+				if err := config.Store.DeletePostByPath(name, url); err != nil {
+					result = result.Failed(err)
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// loadCollection returns the stored posts of a collection keyed by URL, caching them in existing
+func loadCollection(store db.Store, name string, existing map[string]map[string]db.Post) (map[string]db.Post, error) {
+	if posts, ok := existing[name]; ok {
+		return posts, nil
+	}
+	list, err := store.GetPostsByCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	posts := make(map[string]db.Post, len(list))
+	for _, post := range list {
+		posts[post.URL] = post
+	}
+	existing[name] = posts
+	return posts, nil
 }
 
-// createSlug creates a URL-friendly slug from title and path
-func createSlug(title string, relPath string) string {
-	// Use relative path without extension for more stable URLs
-	slug := strings.TrimSuffix(relPath, ".md")
-	slug = filepath.ToSlash(slug)
-	
-	// Replace spaces and special characters
-	slug = strings.ToLower(slug)
-	slug = strings.ReplaceAll(slug, " ", "-")
-	slug = strings.ReplaceAll(slug, "/", "-")
-	
-	// Remove multiple consecutive dashes
-	for strings.Contains(slug, "--") {
-		slug = strings.ReplaceAll(slug, "--", "-")
-	}
-	
-	return slug
+// Failed marks a result as failed with the given error
+func (r Result) Failed(err error) Result {
+	r.Action = ActionFailed
+	r.Error = err.Error()
+	return r
 }
 
 // ClearCollection removes all posts from database before sync
-func ClearCollection(collection *mongo.Collection, collectionName string) error {
+func ClearCollection(store db.Store, collectionName string) error {
 	if collectionName == "" {
 		// Clear all collections
-		return db.DeleteCollection(collection, collectionName)
+		return store.DeleteCollection(collectionName)
 	}
-	return db.DeleteCollection(collection, collectionName)
+	return store.DeleteCollection(collectionName)
 }
@@ -0,0 +1,164 @@
+package filesync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/db/dbtest"
+	// Registers the .puml renderer, as the server does
+	_ "github.com/beldmian/go-markdown-server/plantuml"
+)
+
+// writeFiles creates files (relative path -> content) under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// actions maps the file names of results to their actions
+func actions(t *testing.T, results []Result, err error) map[string]string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]string)
+	for _, r := range results {
+		if r.Action == ActionFailed {
+			t.Errorf("%s failed: %s", r.Path, r.Error)
+		}
+		got[r.URL] = r.Action
+	}
+	return got
+}
+
+// stored reports whether the store has a post with url in collection
+func stored(t *testing.T, store db.Store, collection, url string) bool {
+	t.Helper()
+	posts, err := store.GetPostsByCollection(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, post := range posts {
+		if post.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncReportsChanges(t *testing.T) {
+	// Keep rendered diagrams out of the package directory
+	os.Setenv("DIAGRAM_CACHE", "memory")
+	for name, store := range dbtest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "filesync-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			writeFiles(t, dir, map[string]string{
+				"Guides/setup.md": "# Setup\n\nInstall it.\n",
+				"Guides/start.md": "# Getting started\n\nRead setup first.\n",
+			})
+			config := SyncConfig{RootDir: dir, Store: store, Prefix: dbtest.Collection("sync") + "/"}
+			collection := config.Prefix + "Guides"
+			defer ClearCollection(store, collection)
+
+			check := func(step string, want map[string]string, results []Result, err error) {
+				got := actions(t, results, err)
+				for url, action := range want {
+					if got[url] != action {
+						t.Errorf("%s: %s was %q, want %q", step, url, got[url], action)
+					}
+				}
+				if len(got) != len(want) {
+					t.Errorf("%s: results %v, want %v", step, got, want)
+				}
+			}
+
+			results, err := SyncAllFiles(config)
+			check("first sync", map[string]string{"setup": ActionAdded, "start": ActionAdded}, results, err)
+
+			results, err = SyncAllFiles(config)
+			check("resync", map[string]string{"setup": ActionUnchanged, "start": ActionUnchanged}, results, err)
+
+			writeFiles(t, dir, map[string]string{"Guides/start.md": "# Getting started\n\nRead setup, then deploy.\n"})
+			results, err = SyncAllFiles(config)
+			check("edit", map[string]string{"setup": ActionUnchanged, "start": ActionUpdated}, results, err)
+
+			os.Remove(filepath.Join(dir, "Guides", "setup.md"))
+			config.Prune, config.DryRun = true, true
+			results, err = SyncAllFiles(config)
+			check("dry run", map[string]string{"setup": ActionDeleted, "start": ActionUnchanged}, results, err)
+			if !stored(t, store, collection, "setup") {
+				t.Errorf("dry run deleted the post")
+			}
+
+			config.DryRun = false
+			results, err = SyncAllFiles(config)
+			check("prune", map[string]string{"setup": ActionDeleted, "start": ActionUnchanged}, results, err)
+			if stored(t, store, collection, "setup") {
+				t.Errorf("pruned post still stored")
+			}
+		})
+	}
+}
+
+func TestResyncWithFrontMatterReportsUnchanged(t *testing.T) {
+	// Keep rendered diagrams out of the package directory
+	os.Setenv("DIAGRAM_CACHE", "memory")
+	dir, err := ioutil.TempDir("", "filesync-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Front matter, a link and a missing diagram are all part of what unchanged compares
+	writeFiles(t, dir, map[string]string{
+		"Guides/setup.md": "# Setup\n\nInstall it.\n",
+		"Guides/start.md": "---\n" +
+			"title: Getting started\n" +
+			"description: First steps\n" +
+			"tags: [intro, setup]\n" +
+			"authors: [Ann]\n" +
+			"date: 2024-03-01\n" +
+			"weight: 2\n" +
+			"layout: wide\n" +
+			"---\n" +
+			"Read [setup](setup.md) first.\n\n![Flow](flow.puml)\n",
+	})
+
+	for name, store := range dbtest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			config := SyncConfig{RootDir: dir, Store: store, Prefix: dbtest.Collection("sync") + "/"}
+			defer ClearCollection(store, config.Prefix+"Guides")
+
+			if _, err := SyncAllFiles(config); err != nil {
+				t.Fatal(err)
+			}
+			results, err := SyncAllFiles(config)
+			for url, action := range actions(t, results, err) {
+				if action != ActionUnchanged {
+					t.Errorf("resync: %s %s, want %s", url, action, ActionUnchanged)
+				}
+			}
+
+			post, err := store.GetPost(config.Prefix+"Guides", "start")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(post.Tags) != 2 || len(post.Links) != 1 || len(post.MissingFiles) != 1 {
+				t.Errorf("stored post lost metadata: tags %v, links %v, missing files %v", post.Tags, post.Links, post.MissingFiles)
+			}
+		})
+	}
+}
@@ -3,8 +3,14 @@ module github.com/beldmian/go-markdown-server
 go 1.13
 
 require (
+	github.com/alecthomas/chroma/v2 v2.14.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gorilla/mux v1.7.4
+	github.com/microcosm-cc/bluemonday v1.0.27
 	github.com/russross/blackfriday v2.0.0+incompatible
-	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
+	github.com/shurcooL/sanitized_anchor_name v1.0.0
+	github.com/yuin/goldmark v1.5.6
 	go.mongodb.org/mongo-driver v1.3.3
+	golang.org/x/net v0.26.0
+	gopkg.in/yaml.v3 v3.0.1
 )
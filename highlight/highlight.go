@@ -0,0 +1,200 @@
+package highlight
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// DefaultStyle is the chroma style served when none is configured
+const DefaultStyle = "github"
+
+// Highlighter turns fenced code blocks in markdown into highlighted HTML
+type Highlighter struct {
+	// Style is the chroma style used by CSS when no style is requested
+	Style string
+	// LineNumbers is the default for fences without a linenos attribute
+	LineNumbers bool
+}
+
+// FromEnv creates a highlighter configured by HIGHLIGHT_STYLE and HIGHLIGHT_LINE_NUMBERS
+func FromEnv() (*Highlighter, error) {
+	h := &Highlighter{Style: DefaultStyle, LineNumbers: true}
+	if style := os.Getenv("HIGHLIGHT_STYLE"); style != "" {
+		if _, ok := styles.Registry[style]; !ok {
+			return nil, fmt.Errorf("unknown highlight style: %s", style)
+		}
+		h.Style = style
+	}
+	if v := os.Getenv("HIGHLIGHT_LINE_NUMBERS"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HIGHLIGHT_LINE_NUMBERS: %s", v)
+		}
+		h.LineNumbers = on
+	}
+	return h, nil
+}
+
+// Fence is the parsed info string of a fenced code block: ```go {3-5,8 linenos=false}
+type Fence struct {
+	Language string
+	// Lines are inclusive ranges of lines to highlight
+	Lines [][2]int
+	// Attrs holds key=value attributes
+	Attrs map[string]string
+}
+
+// ParseFence parses a fence info string
+func ParseFence(info string) Fence {
+	info = strings.TrimSpace(info)
+	fence := Fence{Attrs: make(map[string]string)}
+	attrs := ""
+	if i := strings.IndexByte(info, '{'); i >= 0 {
+		attrs = strings.TrimSuffix(info[i+1:], "}")
+		info = info[:i]
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		fence.Language = strings.ToLower(fields[0])
+	}
+	for _, item := range strings.FieldsFunc(attrs, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			fence.Attrs[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"'`)
+			continue
+		}
+		bounds := strings.SplitN(item, "-", 2)
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = strconv.Atoi(bounds[1]); err != nil || to < from {
+				continue
+			}
+		}
+		fence.Lines = append(fence.Lines, [2]int{from, to})
+	}
+	return fence
+}
+
+// Markdown replaces every fenced code block whose language chroma knows with
+// a highlighted <pre> block. Raw <pre> blocks pass through both markdown
+// renderers untouched; other blocks are left for the renderer.
+func (h *Highlighter) Markdown(text string) string {
+	lines := strings.Split(text, "\n")
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		marker, info, ok := openingFence(lines[i])
+		if !ok {
+			out = append(out, lines[i])
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && !closesFence(lines[end], marker) {
+			end++
+		}
+		if end == len(lines) {
+			// Unclosed fence: leave the rest of the document alone
+			out = append(out, lines[i:]...)
+			break
+		}
+		// Fences inside list items keep their indentation, like the renderer would
+		indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " "))]
+		code := make([]string, 0, end-i-1)
+		for _, line := range lines[i+1 : end] {
+			code = append(code, strings.TrimPrefix(line, indent))
+		}
+		if highlighted, ok := h.Code(strings.Join(code, "\n")+"\n", ParseFence(info)); ok {
+			out = append(out, "")
+			for _, line := range strings.Split(highlighted, "\n") {
+				out = append(out, indent+line)
+			}
+			out = append(out, "")
+		} else {
+			out = append(out, lines[i:end+1]...)
+		}
+		i = end
+	}
+	return strings.Join(out, "\n")
+}
+
+// Code highlights a block of code; ok is false when the language is unknown
+func (h *Highlighter) Code(code string, fence Fence) (string, bool) {
+	if fence.Language == "" {
+		return "", false
+	}
+	lexer := lexers.Get(fence.Language)
+	if lexer == nil {
+		return "", false
+	}
+	lineNumbers := h.LineNumbers
+	if v, ok := fence.Attrs["linenos"]; ok {
+		lineNumbers, _ = strconv.ParseBool(v)
+	}
+	formatter := html.New(
+		html.WithClasses(true),
+		html.WithLineNumbers(lineNumbers),
+		html.HighlightLines(fence.Lines),
+	)
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := formatter.Format(&buf, styles.Get(h.Style), iterator); err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(buf.String()), true
+}
+
+// CSS returns the stylesheet for a chroma style ("" for the configured one)
+func (h *Highlighter) CSS(style string) ([]byte, error) {
+	if style == "" {
+		style = h.Style
+	}
+	s, ok := styles.Registry[style]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style: %s", style)
+	}
+	var buf bytes.Buffer
+	if err := html.New(html.WithClasses(true)).WriteCSS(&buf, s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openingFence recognizes ``` or ~~~ (up to three spaces indented) and returns the marker and info string
+func openingFence(line string) (marker string, info string, ok bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return "", "", false
+	}
+	for _, c := range []string{"`", "~"} {
+		n := 0
+		for n < len(trimmed) && trimmed[n] == c[0] {
+			n++
+		}
+		if n >= 3 {
+			info = trimmed[n:]
+			if c == "`" && strings.Contains(info, "`") {
+				return "", "", false
+			}
+			return trimmed[:n], info, true
+		}
+	}
+	return "", "", false
+}
+
+// closesFence reports whether line closes a fence opened with marker
+func closesFence(line string, marker string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, marker) && strings.Trim(trimmed, marker[:1]) == ""
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/diff"
+	"github.com/gorilla/mux"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// loadRevisions returns the readable post behind url together with its history.
+// The collection query parameter picks the post when url exists in several collections.
+func loadRevisions(r *http.Request, url string) (db.Post, []db.Revision, error) {
+	post, err := findPost(r, r.URL.Query().Get("collection"), url)
+	if err != nil {
+		return db.Post{}, nil, err
+	}
+	revisions, err := store.GetRevisions(post.Collection, post.URL)
+	if err != nil {
+		return db.Post{}, nil, err
+	}
+	return post, revisions, nil
+}
+
+// findRevision returns the revision with the given number
+func findRevision(revisions []db.Revision, number int) (db.Revision, bool) {
+	for _, rev := range revisions {
+		if rev.Number == number {
+			return rev, true
+		}
+	}
+	return db.Revision{}, false
+}
+
+// diffRange resolves the from/to query parameters. By default the latest
+// revision is compared with the one before it.
+func diffRange(r *http.Request, revisions []db.Revision) (db.Revision, db.Revision, error) {
+	if len(revisions) == 0 {
+		return db.Revision{}, db.Revision{}, fmt.Errorf("post has no revisions")
+	}
+	v := r.URL.Query()
+	to := revisions[len(revisions)-1].Number
+	if s := v.Get("to"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return db.Revision{}, db.Revision{}, fmt.Errorf("invalid to revision: %s", s)
+		}
+		to = n
+	}
+	from := to - 1
+	if s := v.Get("from"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return db.Revision{}, db.Revision{}, fmt.Errorf("invalid from revision: %s", s)
+		}
+		from = n
+	}
+
+	toRev, ok := findRevision(revisions, to)
+	if !ok {
+		return db.Revision{}, db.Revision{}, fmt.Errorf("revision %d not found", to)
+	}
+	// Revision 0 is the empty document, so the first revision diffs as all additions
+	fromRev := db.Revision{Number: 0}
+	if from > 0 {
+		if fromRev, ok = findRevision(revisions, from); !ok {
+			return db.Revision{}, db.Revision{}, fmt.Errorf("revision %d not found", from)
+		}
+	}
+	return fromRev, toRev, nil
+}
+
+// postHistoryAPIHandler returns the revision list of a post (without bodies)
+func postHistoryAPIHandler(w http.ResponseWriter, r *http.Request) {
+	post, revisions, err := loadRevisions(r, mux.Vars(r)["url"])
+	if err == db.ErrNotFound {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err == errAmbiguousPost {
+		http.Error(w, err.Error()+"; pass ?collection=", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range revisions {
+		revisions[i].Body = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"collection": post.Collection,
+		"url":        post.URL,
+		"revisions":  revisions,
+	})
+}
+
+// postDiffAPIHandler returns a line diff between two revisions of a post
+func postDiffAPIHandler(w http.ResponseWriter, r *http.Request) {
+	post, revisions, err := loadRevisions(r, mux.Vars(r)["url"])
+	if err == db.ErrNotFound {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err == errAmbiguousPost {
+		http.Error(w, err.Error()+"; pass ?collection=", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	from, to, err := diffRange(r, revisions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lines := diff.Lines(from.Body, to.Body)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"collection": post.Collection,
+		"url":        post.URL,
+		"from":       from.Number,
+		"to":         to.Number,
+		"lines":      lines,
+		"unified":    diff.Unified(revisionName(from), revisionName(to), lines, diffContext),
+	})
+}
+
+// postHistoryHandler renders the revision list of a post
+func postHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["name"]
+	post, revisions, err := loadRevisions(r, url)
+	if err != nil {
+		errorNotFoundPage(w)
+		return
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# History: %s\n\n", post.Title)
+	fmt.Fprintf(&out, "[Back to page](%s)\n\n", postPath(post))
+	out.WriteString("| Revision | Date | Source | Hash | |\n|---|---|---|---|---|\n")
+	for i := len(revisions) - 1; i >= 0; i-- {
+		rev := revisions[i]
+		fmt.Fprintf(&out, "| %d | %s | %s | `%s` | [diff](%s&to=%d) |\n",
+			rev.Number, rev.CreatedAt.Format("2006-01-02 15:04:05"), rev.Source, rev.Hash[:12], postDiffPath(post), rev.Number)
+	}
+
+	renderMarkdownPage(w, out.String())
+}
+
+// postDiffHandler renders the diff between two revisions of a post
+func postDiffHandler(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["name"]
+	post, revisions, err := loadRevisions(r, url)
+	if err != nil {
+		errorNotFoundPage(w)
+		return
+	}
+	from, to, err := diffRange(r, revisions)
+	if err != nil {
+		errorNotFoundPage(w)
+		return
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# %s: revision %d → %d\n\n", post.Title, from.Number, to.Number)
+	fmt.Fprintf(&out, "[Back to history](%s)\n\n", postHistoryPath(post))
+	out.WriteString("```diff\n")
+	out.WriteString(diff.Unified(revisionName(from), revisionName(to), diff.Lines(from.Body, to.Body), diffContext))
+	out.WriteString("```\n")
+
+	renderMarkdownPage(w, out.String())
+}
+
+// revisionName labels a revision in unified diff headers
+func revisionName(rev db.Revision) string {
+	if rev.Number == 0 {
+		return "/dev/null"
+	}
+	return fmt.Sprintf("revision %d (%s, %s)", rev.Number, rev.Source, rev.CreatedAt.Format("2006-01-02 15:04:05"))
+}
+
+// renderMarkdownPage renders markdown into content.html
+func renderMarkdownPage(w http.ResponseWriter, markdown string) {
+	tmpl := template.Must(template.ParseFiles("content.html"))
+	output := renderMarkdown(markdown)
+	tmpl.ExecuteTemplate(w, "content", contentPage{Content: output})
+}
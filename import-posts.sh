@@ -1,12 +1,17 @@
 #!/bin/bash
 
 # Import markdown files to go-markdown-server using modern API
-# Usage: ./import-posts.sh <directory-with-md-files> [collection-name]
+# Usage: API_TOKEN=<token> ./import-posts.sh <directory-with-md-files> [collection-name]
 
 SERVER_URL="http://localhost:8080"
 MD_DIR="${1:-.}"
 COLLECTION_NAME="${2}"
 
+if [ -z "$API_TOKEN" ]; then
+    echo "Error: API_TOKEN is not set (create one with: ./goapp token create --name import --scope write)"
+    exit 1
+fi
+
 if [ ! -d "$MD_DIR" ]; then
     echo "Error: Directory $MD_DIR does not exist"
     exit 1
@@ -36,7 +41,7 @@ fi
 
 # Build curl command with all files
 echo "Uploading files to collection '$COLLECTION_NAME'..."
-curl_cmd="curl -s -X POST \"${SERVER_URL}/api/collection/create\" -F \"name=${COLLECTION_NAME}\""
+curl_cmd="curl -s -X POST \"${SERVER_URL}/api/collection/create\" -H \"Authorization: Bearer ${API_TOKEN}\" -F \"name=${COLLECTION_NAME}\""
 
 # Add each markdown file
 while IFS= read -r file; do
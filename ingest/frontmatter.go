@@ -0,0 +1,173 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+// Front matter keys mapped onto typed db.Post fields; everything else goes to Post.Meta
+var typedKeys = map[string]bool{
+	"title": true, "description": true, "tags": true, "author": true, "authors": true,
+	"date": true, "weight": true, "order": true, "draft": true, "aliases": true,
+}
+
+// dateLayouts are the accepted formats of the date key
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// FrontMatter is the parsed YAML block at the top of a markdown file
+type FrontMatter struct {
+	Title       string     `yaml:"title"`
+	Description string     `yaml:"description"`
+	Tags        stringList `yaml:"tags"`
+	Author      stringList `yaml:"author"`
+	Authors     stringList `yaml:"authors"`
+	Date        string     `yaml:"date"`
+	Weight      *int       `yaml:"weight"`
+	Order       *int       `yaml:"order"`
+	Draft       bool       `yaml:"draft"`
+	Aliases     stringList `yaml:"aliases"`
+
+	// Raw holds every key, including the typed ones above
+	Raw map[string]interface{} `yaml:"-"`
+}
+
+// stringList accepts either a YAML sequence or a single comma separated string
+type stringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = nil
+		for _, item := range strings.Split(node.Value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*l = append(*l, item)
+			}
+		}
+		return nil
+	}
+	var items []string
+	if err := node.Decode(&items); err != nil {
+		return err
+	}
+	*l = items
+	return nil
+}
+
+// SplitFrontMatter separates a leading "---" delimited block from the body.
+// ok is false when the text has no front matter.
+func SplitFrontMatter(text string) (block string, body string, ok bool) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	normalized := strings.ReplaceAll(text, "\r\n", "\n")
+	if !strings.HasPrefix(normalized, "---\n") {
+		return "", text, false
+	}
+	rest := normalized[len("---\n"):]
+	// The block ends at the first line that is exactly "---" (or "...")
+	for offset := 0; offset <= len(rest); {
+		end := strings.IndexByte(rest[offset:], '\n')
+		line := rest[offset:]
+		if end >= 0 {
+			line = rest[offset : offset+end]
+		}
+		if trimmed := strings.TrimRight(line, " \t"); trimmed == "---" || trimmed == "..." {
+			body = ""
+			if end >= 0 {
+				body = rest[offset+end+1:]
+			}
+			return rest[:offset], body, true
+		}
+		if end < 0 {
+			break
+		}
+		offset += end + 1
+	}
+	return "", text, false
+}
+
+// ParseFrontMatter decodes a YAML front matter block
+func ParseFrontMatter(block string) (FrontMatter, error) {
+	var fm FrontMatter
+	if strings.TrimSpace(block) == "" {
+		return fm, nil
+	}
+	if err := yaml.Unmarshal([]byte(block), &fm); err != nil {
+		return fm, fmt.Errorf("invalid front matter: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(block), &fm.Raw); err != nil {
+		return fm, fmt.Errorf("invalid front matter: %w", err)
+	}
+	return fm, nil
+}
+
+// Apply copies the front matter onto a post
+func (fm FrontMatter) Apply(post *db.Post) error {
+	if fm.Title != "" {
+		post.Title = fm.Title
+	}
+	post.Description = fm.Description
+	post.Tags = normalizeTags(fm.Tags)
+	post.Authors = append(append([]string(nil), fm.Authors...), fm.Author...)
+	post.Draft = fm.Draft
+	post.Aliases = fm.Aliases
+
+	switch {
+	case fm.Weight != nil:
+		post.Weight = *fm.Weight
+	case fm.Order != nil:
+		post.Weight = *fm.Order
+	}
+
+	if fm.Date != "" {
+		date, err := parseDate(fm.Date)
+		if err != nil {
+			return err
+		}
+		post.Date = &date
+	}
+
+	for key, value := range fm.Raw {
+		if typedKeys[key] {
+			continue
+		}
+		if post.Meta == nil {
+			post.Meta = make(map[string]interface{})
+		}
+		post.Meta[key] = value
+	}
+	return nil
+}
+
+// normalizeTags canonicalizes tags and drops duplicates
+func normalizeTags(tags []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		tag = db.NormalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// parseDate accepts the layouts in dateLayouts
+func parseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid front matter date %q (use YYYY-MM-DD or RFC 3339)", value)
+}
@@ -0,0 +1,175 @@
+package ingest
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+// File is a markdown file entering the pipeline
+type File struct {
+	// Path is the slash-separated path relative to the top-level collection, e.g. "guides/setup.md"
+	Path string
+	Data []byte
+	// Origin is recorded as the post source (db.SourceSync, db.SourceUpload, db.SourceAPI)
+	Origin string
+	// Collection is the (possibly nested) collection of the file's directory, e.g. "content/Docs/guides"
+	Collection string
+	// BaseDir is the directory under content/ used to resolve .puml references ("" to disable)
+	BaseDir string
+	// Source is the path relative to the root of the import (e.g. "Docs/guides/setup.md"); defaults to Path
+	Source string
+	// Locate maps other files of the import for link resolution; nil keeps links inside the top-level collection
+	Locate Locator
+}
+
+// Document is the working state passed from stage to stage
+type Document struct {
+	File        File
+	FrontMatter FrontMatter
+	Post        db.Post
+}
+
+// Stage is one step of the ingestion pipeline
+type Stage interface {
+	Name() string
+	Process(doc *Document) error
+}
+
+// StageFunc adapts a function to the Stage interface
+type StageFunc struct {
+	StageName string
+	Fn        func(doc *Document) error
+}
+
+// Name returns the stage name
+func (s StageFunc) Name() string { return s.StageName }
+
+// Process runs the stage function
+func (s StageFunc) Process(doc *Document) error { return s.Fn(doc) }
+
+// Pipeline turns files into posts by running stages in order
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline creates a pipeline from the given stages
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Default returns the standard pipeline used by sync, upload and /add
+func Default() *Pipeline {
+	return NewPipeline(DefaultStages()...)
+}
+
+// DefaultStages returns the standard stages in order
+func DefaultStages() []Stage {
+	return []Stage{
+		FrontMatterStage{},
+		IndexStage{},
+		TitleStage{},
+		SlugStage{},
+		DiagramStage{},
+		LinkStage{},
+	}
+}
+
+// Stages returns a copy of the pipeline's stages
+func (p *Pipeline) Stages() []Stage {
+	return append([]Stage(nil), p.stages...)
+}
+
+// Replace returns a new pipeline with the named stage swapped for stage
+func (p *Pipeline) Replace(name string, stage Stage) *Pipeline {
+	stages := p.Stages()
+	for i, s := range stages {
+		if s.Name() == name {
+			stages[i] = stage
+		}
+	}
+	return NewPipeline(stages...)
+}
+
+// Without returns a new pipeline without the named stage
+func (p *Pipeline) Without(name string) *Pipeline {
+	var stages []Stage
+	for _, s := range p.stages {
+		if s.Name() != name {
+			stages = append(stages, s)
+		}
+	}
+	return NewPipeline(stages...)
+}
+
+// Ingest runs every stage over f and returns the resulting post
+func (p *Pipeline) Ingest(f File) (db.Post, error) {
+	f.Path = cleanPath(f.Path)
+	if f.Source != "" {
+		f.Source = cleanPath(f.Source)
+	}
+	doc := &Document{
+		File: f,
+		Post: db.Post{
+			Body:       string(f.Data),
+			Collection: f.Collection,
+			Source:     f.Origin,
+		},
+	}
+	for _, stage := range p.stages {
+		if err := stage.Process(doc); err != nil {
+			return db.Post{}, fmt.Errorf("%s: %s stage: %w", f.Path, stage.Name(), err)
+		}
+	}
+	return doc.Post, nil
+}
+
+// IsMarkdown reports whether a file name has a markdown extension
+func IsMarkdown(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".md")
+}
+
+// Key returns the URL and index flag a file at relPath in collection (the
+// collection of the file's own directory) is stored under.
+// It is what SlugStage and IndexStage produce, so deletions can find the post
+// without reading the file.
+func Key(collection, relPath string) (url string, isIndex bool) {
+	relPath = cleanPath(relPath)
+	if IsIndexPath(relPath) {
+		return IndexURL(collection), true
+	}
+	return Slug(relPath), false
+}
+
+// cleanPath normalizes a file path to a relative, slash separated one
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean(strings.ReplaceAll(p, "\\", "/")), "/")
+}
+
+// IsIndexPath reports whether relPath is the landing page of its directory
+// (index.md or README.md); File.Collection is then that directory's collection
+func IsIndexPath(relPath string) bool {
+	lower := strings.ToLower(path.Base(relPath))
+	return lower == "index.md" || lower == "readme.md"
+}
+
+// IndexURL returns the URL of a collection's index post
+func IndexURL(collection string) string {
+	// Replace slashes with dashes for URL-friendly index slug
+	return strings.ReplaceAll(collection, "/", "-") + "-index"
+}
+
+// Slug creates a URL-friendly slug from a path relative to the collection root
+func Slug(relPath string) string {
+	slug := strings.TrimSuffix(relPath, path.Ext(relPath))
+	slug = strings.ToLower(slug)
+	slug = strings.NewReplacer(" ", "-", "_", "-", "/", "-").Replace(slug)
+
+	// Remove multiple consecutive dashes
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+	return strings.Trim(slug, "-")
+}
@@ -0,0 +1,192 @@
+package ingest
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/russross/blackfriday"
+)
+
+// Locator maps a slash separated path relative to the source root to the
+// collection of its directory and the path Key expects. ok is false when the
+// path is outside anything the source imports.
+type Locator func(source string) (collection string, relPath string, ok bool)
+
+// referenceDef matches a reference style link definition: [id]: destination "title"
+var referenceDef = regexp.MustCompile(`^( {0,3}\[[^\]]+\]:[ \t]*)(<[^>]*>|\S+)(.*)$`)
+
+// PostPath returns the canonical URL of a post: /content/{collection}/{url}
+func PostPath(collection, postURL string) string {
+	u := url.URL{Path: "/content/" + collection + "/" + postURL}
+	return u.EscapedPath()
+}
+
+// CollectionPath returns the URL of a collection page
+func CollectionPath(collection string) string {
+	u := url.URL{Path: "/content/" + collection}
+	return u.EscapedPath()
+}
+
+// ResolveLinks rewrites links to other markdown files into post URLs.
+// Destinations are taken from the markdown AST and resolved relative to the
+// file's location: [x](../api/setup.md#auth) in "guides/intro.md" becomes
+// /content/{collection}/{url}#auth. External, absolute and fragment-only links,
+// links that leave the source and anything inside code are left alone.
+func ResolveLinks(body string, file File) string {
+	body, _ = resolveLinks(body, file)
+	return body
+}
+
+// resolveLinks rewrites body like ResolveLinks and also returns the posts linked to
+func resolveLinks(body string, file File) (string, []db.PostRef) {
+	targets := make(map[string]string)
+	var refs []db.PostRef
+	seen := make(map[db.PostRef]bool)
+	for _, dest := range LinkDestinations(body) {
+		if _, done := targets[dest]; done {
+			continue
+		}
+		if target, ref, ok := resolveLink(dest, file); ok {
+			targets[dest] = target
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return body, refs
+	}
+
+	var pairs []string
+	for dest, target := range targets {
+		pairs = append(pairs,
+			"]("+dest+")", "]("+target+")",
+			"]("+dest+" ", "]("+target+" ",
+			"](<"+dest+">", "](<"+target+">",
+		)
+	}
+	inline := strings.NewReplacer(pairs...)
+
+	lines := strings.Split(body, "\n")
+	fence := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+		if m := referenceDef.FindStringSubmatch(line); m != nil {
+			dest := strings.TrimSuffix(strings.TrimPrefix(m[2], "<"), ">")
+			if target, ok := targets[dest]; ok {
+				lines[i] = m[1] + target + m[3]
+			}
+			continue
+		}
+		// Odd segments between backticks are code spans
+		segments := strings.Split(line, "`")
+		for j := 0; j < len(segments); j += 2 {
+			segments[j] = inline.Replace(segments[j])
+		}
+		lines[i] = strings.Join(segments, "`")
+	}
+	return strings.Join(lines, "\n"), refs
+}
+
+// LinkDestinations returns the destination of every link in body, in document order
+func LinkDestinations(body string) []string {
+	md := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	var dests []string
+	md.Parse([]byte(body)).Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if entering && node.Type == blackfriday.Link {
+			dests = append(dests, string(node.LinkData.Destination))
+		}
+		return blackfriday.GoToNext
+	})
+	return dests
+}
+
+// ResolveLink maps one relative link destination of file to the URL of the
+// post (or, for index.md/README.md, the collection page) it points at
+func ResolveLink(dest string, file File) (string, bool) {
+	target, _, ok := resolveLink(dest, file)
+	return target, ok
+}
+
+// resolveLink is ResolveLink, also returning the post (or index post) linked to
+func resolveLink(dest string, file File) (string, db.PostRef, bool) {
+	if dest == "" || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "/") || hasScheme(dest) {
+		return "", db.PostRef{}, false
+	}
+	target, fragment := dest, ""
+	if i := strings.IndexByte(dest, '#'); i >= 0 {
+		target, fragment = dest[:i], dest[i:]
+	}
+	target, err := url.PathUnescape(target)
+	if err != nil || strings.Contains(target, "?") || !IsMarkdown(target) {
+		return "", db.PostRef{}, false
+	}
+
+	source := file.Source
+	if source == "" {
+		source = file.Path
+	}
+	target = path.Join(path.Dir(source), target)
+	if target == ".." || strings.HasPrefix(target, "../") {
+		return "", db.PostRef{}, false
+	}
+
+	locate := file.Locate
+	if locate == nil {
+		locate = sameTree(file)
+	}
+	collection, relPath, ok := locate(target)
+	if !ok {
+		return "", db.PostRef{}, false
+	}
+	postURL, isIndex := Key(collection, relPath)
+	ref := db.PostRef{Collection: collection, URL: postURL}
+	if isIndex {
+		return CollectionPath(collection) + fragment, ref, true
+	}
+	return PostPath(collection, postURL) + fragment, ref, true
+}
+
+// sameTree is the default Locator: the source root is the top-level
+// collection of file, and sub-directories are its nested collections
+func sameTree(file File) Locator {
+	top := file.Collection
+	if dir := path.Dir(file.Path); dir != "." {
+		top = strings.TrimSuffix(top, "/"+dir)
+	}
+	return func(source string) (string, string, bool) {
+		if dir := path.Dir(source); dir != "." {
+			return top + "/" + dir, source, true
+		}
+		return top, source, true
+	}
+}
+
+// hasScheme reports whether dest starts with a URL scheme such as https: or mailto:
+func hasScheme(dest string) bool {
+	for i, c := range dest {
+		switch {
+		case c == ':':
+			return i > 0
+		case c == '/' || c == '.' && i == 0:
+			return false
+		case !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package ingest
+
+import (
+	"path"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/diagram"
+)
+
+// Stage names, usable with Pipeline.Replace and Pipeline.Without
+const (
+	StageFrontMatter = "frontmatter"
+	StageIndex       = "index"
+	StageTitle       = "title"
+	StageSlug        = "slug"
+	StageDiagrams    = "diagrams"
+	StageLinks       = "links"
+)
+
+// FrontMatterStage strips a leading YAML block and copies it onto the post
+type FrontMatterStage struct{}
+
+// Name ...
+func (FrontMatterStage) Name() string { return StageFrontMatter }
+
+// Process ...
+func (FrontMatterStage) Process(doc *Document) error {
+	block, body, ok := SplitFrontMatter(doc.Post.Body)
+	if !ok {
+		return nil
+	}
+	fm, err := ParseFrontMatter(block)
+	if err != nil {
+		return err
+	}
+	doc.FrontMatter = fm
+	doc.Post.Body = strings.TrimSpace(body)
+	return fm.Apply(&doc.Post)
+}
+
+// IndexStage marks index.md / README.md as the index of the file's collection
+type IndexStage struct{}
+
+// Name ...
+func (IndexStage) Name() string { return StageIndex }
+
+// Process ...
+func (IndexStage) Process(doc *Document) error {
+	doc.Post.IsIndex = IsIndexPath(doc.File.Path)
+	return nil
+}
+
+// TitleStage keeps a front matter title, else takes the first H1, then the file name
+type TitleStage struct{}
+
+// Name ...
+func (TitleStage) Name() string { return StageTitle }
+
+// Process ...
+func (TitleStage) Process(doc *Document) error {
+	if doc.Post.Title != "" {
+		return nil
+	}
+	for _, line := range strings.Split(doc.Post.Body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# ") {
+			doc.Post.Title = strings.TrimSpace(strings.TrimPrefix(line, "# "))
+			return nil
+		}
+	}
+	name := path.Base(doc.File.Path)
+	doc.Post.Title = strings.TrimSuffix(name, path.Ext(name))
+	return nil
+}
+
+// SlugStage derives the post URL from its path (see Key)
+type SlugStage struct{}
+
+// Name ...
+func (SlugStage) Name() string { return StageSlug }
+
+// Process ...
+func (SlugStage) Process(doc *Document) error {
+	if doc.Post.IsIndex {
+		doc.Post.URL = IndexURL(doc.Post.Collection)
+	} else {
+		doc.Post.URL = Slug(doc.File.Path)
+	}
+	return nil
+}
+
+// DiagramStage renders diagram blocks (```plantuml, ```dot, ...) and diagram
+// file references (.puml, .dot, ...) into image links
+type DiagramStage struct{}
+
+// Name ...
+func (DiagramStage) Name() string { return StageDiagrams }
+
+// Process ...
+func (DiagramStage) Process(doc *Document) error {
+	// Unresolved references fall back to a .png link; keep them for the link checker
+	doc.Post.MissingFiles = diagram.MissingFiles(doc.Post.Body, doc.File.BaseDir)
+	doc.Post.Body = diagram.Process(doc.Post.Body, doc.File.BaseDir)
+	return nil
+}
+
+// LinkStage resolves relative links to other markdown files into post URLs
+// (see ResolveLinks) and records the linked posts in Post.Links
+type LinkStage struct{}
+
+// Name ...
+func (LinkStage) Name() string { return StageLinks }
+
+// Process ...
+func (LinkStage) Process(doc *Document) error {
+	var links []db.PostRef
+	doc.Post.Body, links = resolveLinks(doc.Post.Body, doc.File)
+	self := db.PostRef{Collection: doc.Post.Collection, URL: doc.Post.URL}
+	doc.Post.Links = nil
+	for _, link := range links {
+		if link != self {
+			doc.Post.Links = append(doc.Post.Links, link)
+		}
+	}
+	return nil
+}
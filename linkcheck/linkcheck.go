@@ -0,0 +1,173 @@
+package linkcheck
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/ingest"
+	"github.com/beldmian/go-markdown-server/render"
+	"github.com/beldmian/go-markdown-server/toc"
+)
+
+// Kinds of broken link
+const (
+	KindPost   = "post"   // the target post or collection does not exist
+	KindAnchor = "anchor" // the target exists but has no heading with that id
+	KindFile   = "file"   // a referenced file (e.g. .puml) was not found on import
+)
+
+// Broken is one dead link
+type Broken struct {
+	Collection string `json:"collection"` // collection of the post containing the link
+	URL        string `json:"url"`        // url of the post containing the link
+	Link       string `json:"link"`
+	Kind       string `json:"kind"`
+	Detail     string `json:"detail"`
+}
+
+// site indexes the posts being checked
+type site struct {
+	posts       map[string]db.Post // collection + "/" + url -> post
+	byURL       map[string]int     // url -> number of posts
+	collections map[string]bool    // collections and their ancestors
+	anchors     map[string]map[string]bool
+}
+
+// Check returns the broken internal links of posts. Links are checked against
+// posts itself, so pass every post a link may point to.
+func Check(posts []db.Post) []Broken {
+	s := newSite(posts)
+	var broken []Broken
+	for _, post := range posts {
+		report := func(link, kind, detail string) {
+			broken = append(broken, Broken{Collection: post.Collection, URL: post.URL, Link: link, Kind: kind, Detail: detail})
+		}
+		for _, file := range post.MissingFiles {
+			report(file, KindFile, "file not found")
+		}
+		for _, link := range ingest.LinkDestinations(post.Body) {
+			if kind, detail, ok := s.check(post, link); !ok {
+				report(link, kind, detail)
+			}
+		}
+	}
+	sort.SliceStable(broken, func(i, j int) bool {
+		if broken[i].Collection != broken[j].Collection {
+			return broken[i].Collection < broken[j].Collection
+		}
+		return broken[i].URL < broken[j].URL
+	})
+	return broken
+}
+
+// newSite indexes posts by key, url and collection
+func newSite(posts []db.Post) *site {
+	s := &site{
+		posts:       make(map[string]db.Post, len(posts)),
+		byURL:       make(map[string]int),
+		collections: make(map[string]bool),
+		anchors:     make(map[string]map[string]bool),
+	}
+	for _, post := range posts {
+		s.posts[post.Collection+"/"+post.URL] = post
+		s.byURL[post.URL]++
+		for c := post.Collection; c != ""; c = parent(c) {
+			s.collections[c] = true
+		}
+	}
+	return s
+}
+
+// check reports whether one link of post resolves; kind and detail describe the failure
+func (s *site) check(post db.Post, link string) (kind string, detail string, ok bool) {
+	target, fragment := link, ""
+	if i := strings.IndexByte(link, '#'); i >= 0 {
+		target, fragment = link[:i], link[i+1:]
+	}
+	switch {
+	case target == "":
+		return s.checkAnchor(post, fragment)
+	case strings.HasPrefix(target, "/content/"):
+		name, err := url.PathUnescape(strings.TrimPrefix(target, "/content/"))
+		if err != nil {
+			return KindPost, "invalid path", false
+		}
+		name = strings.TrimSuffix(name, "/")
+		if s.collections[name] {
+			if index, found := s.index(name); found {
+				return s.checkAnchor(index, fragment)
+			}
+			return "", "", true
+		}
+		if linked, found := s.posts[name]; found {
+			return s.checkAnchor(linked, fragment)
+		}
+		return KindPost, "no such post or collection", false
+	case strings.HasPrefix(target, "/post/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(target, "/post/"))
+		if s.byURL[name] == 0 {
+			return KindPost, "no such post", false
+		}
+		return "", "", true
+	case !strings.Contains(target, ":") && ingest.IsMarkdown(target):
+		// Relative links that the import could not map to a post
+		return KindPost, "file outside the imported tree", false
+	}
+	return "", "", true
+}
+
+// index returns the index post of a collection
+func (s *site) index(collection string) (db.Post, bool) {
+	post, found := s.posts[collection+"/"+ingest.IndexURL(collection)]
+	return post, found && post.IsIndex
+}
+
+// checkAnchor reports whether post has a heading with id fragment
+func (s *site) checkAnchor(post db.Post, fragment string) (string, string, bool) {
+	if fragment == "" {
+		return "", "", true
+	}
+	key := post.Collection + "/" + post.URL
+	anchors, ok := s.anchors[key]
+	if !ok {
+		anchors = make(map[string]bool)
+		for _, id := range Anchors(post.Body) {
+			anchors[id] = true
+		}
+		s.anchors[key] = anchors
+	}
+	if anchors[fragment] {
+		return "", "", true
+	}
+	return KindAnchor, fmt.Sprintf("no heading #%s in %s", fragment, post.Title), false
+}
+
+// Renderer renders posts to read their heading ids from. The server sets it to
+// its own renderer; the default is goldmark with the default extensions.
+var Renderer, _ = render.New("", render.Options{})
+
+// Anchors returns the heading ids of a markdown document as the served page has
+// them: explicit {#id} or derived from the rendered heading text, with -1, -2,
+// ... appended to repeats
+func Anchors(body string) []string {
+	out, err := Renderer.Render([]byte(body))
+	if err != nil {
+		return nil
+	}
+	ids, err := toc.HeadingIDs(out)
+	if err != nil {
+		return nil
+	}
+	return ids
+}
+
+// parent returns the enclosing collection of a nested one ("" for top-level collections)
+func parent(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package linkcheck
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+func TestCheckReportsBrokenLinks(t *testing.T) {
+	posts := []db.Post{
+		{Collection: "docs", URL: "setup", Title: "Setup", Body: "# Setup\n\n## Install\n\nSteps.\n"},
+		{Collection: "docs", URL: "guide", Title: "Guide", Body: "# Guide\n\n" +
+			"[ok](/content/docs/setup) [ok anchor](/content/docs/setup#install) [ok here](#guide)\n\n" +
+			"[gone](/content/docs/missing) [no anchor](/content/docs/setup#uninstall) [no here](#nowhere)\n\n" +
+			"[outside](../other/readme.md) [external](https://example.com/x.md)\n",
+			MissingFiles: []string{"diagrams/flow.puml"}},
+	}
+	want := map[string]string{
+		"diagrams/flow.puml":            KindFile,
+		"/content/docs/missing":         KindPost,
+		"/content/docs/setup#uninstall": KindAnchor,
+		"#nowhere":                      KindAnchor,
+		"../other/readme.md":            KindPost,
+	}
+
+	got := make(map[string]string)
+	for _, b := range Check(posts) {
+		if b.Collection != "docs" || b.URL != "guide" {
+			t.Errorf("%s reported on %s/%s, want docs/guide", b.Link, b.Collection, b.URL)
+		}
+		got[b.Link] = b.Kind
+	}
+	for link, kind := range want {
+		if got[link] != kind {
+			t.Errorf("%s reported as %q, want %q", link, got[link], kind)
+		}
+	}
+	for link := range got {
+		if _, ok := want[link]; !ok {
+			t.Errorf("%s reported but is not broken", link)
+		}
+	}
+}
+
+func TestAnchorsUseRenderedHeadingText(t *testing.T) {
+	// The page shows "See foo", so the id must not include the link target
+	body := "# Guide\n\n## See [foo](x.md)\n\n## See [foo](x.md)\n\n## Custom {#custom}\n"
+	want := []string{"guide", "see-foo", "see-foo-1", "custom"}
+	if got := Anchors(body); !reflect.DeepEqual(got, want) {
+		t.Errorf("Anchors = %v, want %v", got, want)
+	}
+
+	posts := []db.Post{{Collection: "docs", URL: "guide", Title: "Guide", Body: body + "\n[back](#see-foo) [old](#see-foo-x-md)\n"}}
+	var anchors []string
+	for _, b := range Check(posts) {
+		if b.Kind == KindAnchor {
+			anchors = append(anchors, b.Link)
+		}
+	}
+	if len(anchors) != 1 || anchors[0] != "#see-foo-x-md" {
+		t.Errorf("broken anchors %v, want only #see-foo-x-md", anchors)
+	}
+}
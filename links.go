@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/filesync"
+	"github.com/beldmian/go-markdown-server/linkcheck"
+)
+
+// brokenLinksHandler reports dead internal links in the posts the caller may read.
+// ?collection= limits the report to one collection and its sub-collections.
+func brokenLinksHandler(w http.ResponseWriter, r *http.Request) {
+	posts, err := store.GetPosts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	readable := readableFilter(r)
+	visible := []db.Post{}
+	for _, post := range posts {
+		if readable(post.Collection) {
+			visible = append(visible, post)
+		}
+	}
+
+	collection := r.URL.Query().Get("collection")
+	broken := []linkcheck.Broken{}
+	for _, b := range linkcheck.Check(visible) {
+		if collection == "" || filesync.InCollection(b.Collection, collection) {
+			broken = append(broken, b)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(broken)
+}
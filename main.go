@@ -1,8 +1,8 @@
 package main
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -14,12 +14,20 @@ import (
 	"sync"
 	"time"
 
-	"go.mongodb.org/mongo-driver/mongo"
-
+	"github.com/beldmian/go-markdown-server/auth"
 	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/diagram"
+	"github.com/beldmian/go-markdown-server/filesync"
+	"github.com/beldmian/go-markdown-server/highlight"
+	"github.com/beldmian/go-markdown-server/ingest"
+	"github.com/beldmian/go-markdown-server/linkcheck"
 	"github.com/beldmian/go-markdown-server/plantuml"
+	"github.com/beldmian/go-markdown-server/render"
+	"github.com/beldmian/go-markdown-server/sanitize"
+	"github.com/beldmian/go-markdown-server/search"
+	"github.com/beldmian/go-markdown-server/toc"
+	"github.com/beldmian/go-markdown-server/watcher"
 	"github.com/gorilla/mux"
-	"github.com/russross/blackfriday"
 )
 
 // Post ...
@@ -29,13 +37,29 @@ type Post struct {
 	URL   string `json:"url"`
 }
 
+// contentPage is the data passed to the "content" template
+type contentPage struct {
+	Content   template.HTML
+	Post      *db.Post      // The rendered post, if any; gives templates its front matter
+	Backlinks []backlink    // Posts linking to Post, shown as "Referenced by"
+	TOC       template.HTML // Table of contents shown in the sidebar slot, if any
+}
+
+// contentPrefix is prepended to collections imported from SYNC_DIR
+const contentPrefix = "content/"
+
 var (
-	port       string
-	collection *mongo.Collection
-	syncDir    string
-	autoSync   bool
-	clients    = make(map[chan string]bool)
-	clientsMux sync.Mutex
+	port        string
+	store       db.Store
+	index       *search.Index
+	pipeline    = ingest.Default()
+	renderer    render.Renderer
+	sanitizer   *sanitize.Sanitizer
+	highlighter *highlight.Highlighter
+	syncDir     string
+	autoSync    bool
+	clients     = make(map[chan string]*http.Request) // SSE clients and the request that opened them
+	clientsMux  sync.Mutex
 )
 
 func init() {
@@ -50,6 +74,8 @@ func init() {
 	if syncDir == "" {
 		syncDir = "./content"
 	}
+	// Diagram file references are only read from below it
+	diagram.ContentDir = syncDir
 
 	// Auto sync flag
 	autoSyncEnv := os.Getenv("AUTO_SYNC")
@@ -58,21 +84,80 @@ func init() {
 }
 
 func main() {
-	collectionResp, err := db.ConnectToDB()
+	// Without a subcommand (or with flags only) the server is started
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if command == "help" {
+		printUsage()
+		return
+	}
+	
+	// MARKDOWN_RENDERER / MARKDOWN_EXTENSIONS select how posts are rendered
+	mdRenderer, err := render.FromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
-	collection = collectionResp
+	renderer = mdRenderer
+	// Anchors are checked against the headings this renderer produces
+	linkcheck.Renderer = mdRenderer
 	
-	// Check for sync command
-	if len(os.Args) > 1 && os.Args[1] == "sync" {
-		runSync()
-		return
+	// SANITIZE_POLICIES chooses how rendered HTML is cleaned per collection prefix
+	sanitizer, err = sanitize.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	
+	// HIGHLIGHT_STYLE / HIGHLIGHT_LINE_NUMBERS configure code highlighting
+	highlighter, err = highlight.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	
+	// PlantUML registers itself; Graphviz, Mermaid and D2 are used when installed
+	diagram.RegisterLocal()
+	
+	// check-links works on files only and does not need a database
+	if command != "check-links" {
+		storeResp, err := db.OpenStore()
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = storeResp
+	}
+	
+	if err := runCommand(command, args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServe starts the HTTP server
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("port", strings.TrimPrefix(port, ":"), "port to listen on (PORT)")
+	fs.StringVar(&syncDir, "dir", syncDir, "content directory to sync (SYNC_DIR)")
+	fs.BoolVar(&autoSync, "auto-sync", autoSync, "import and watch the content directory (AUTO_SYNC)")
+	fs.Parse(args)
+	port = ":" + *listen
+	diagram.ContentDir = syncDir
+	
+	// Keep a full-text index in sync with every store write
+	indexed, err := search.NewIndexedStore(store)
+	if err != nil {
+		return err
+	}
+	store = indexed
+	index = indexed.Index
+	
+	// Writes by other processes on the same store reach the index on the next refresh
+	if interval := envDuration("SEARCH_REFRESH", time.Minute); interval > 0 {
+		go refreshSearchIndex(indexed, interval)
 	}
 	
 	if autoSync {
 		log.Printf("AUTO_SYNC enabled. Scanning '%s' for markdown collections...", syncDir)
-		if err := autoSyncFromContent(); err != nil {
+		if _, err := autoSyncFromContent(); err != nil {
 			log.Printf("Auto sync warning: %v", err)
 		}
 	} else {
@@ -90,142 +175,171 @@ func main() {
 		go watchContentDirectory()
 	}
 	
-	if err := http.ListenAndServe(port, r); err != nil {
-		log.Fatal(err)
+	return http.ListenAndServe(port, r)
+}
+
+// SyncResult reports what happened to a single file during a sync
+type SyncResult = filesync.Result
+
+// contentPostKey derives the collection, path and URL a file under SYNC_DIR is stored with.
+// Directory naming convention: each directory under SYNC_DIR becomes a collection nested
+// like the directories ("content/Arch/guides"). Files directly under SYNC_DIR go to
+// collection "content/root".
+func contentPostKey(path string) (collectionName string, relPath string, url string, isIndex bool, err error) {
+	// Prefix with "content/" to distinguish from uploaded collections
+	collectionName, relPath, err = filesync.SplitPath(syncDir, contentPrefix, path)
+	if err != nil {
+		return "", "", "", false, err
 	}
+	url, isIndex = ingest.Key(collectionName, relPath)
+	return collectionName, relPath, url, isIndex, nil
 }
 
-func runSync() {
-	log.Printf("Starting file sync from: %s", syncDir)
-	// Import filesync package and run sync
-	log.Fatal("Sync functionality - use /api/sync endpoint")
+// sourcePath returns the slash separated path of a file below SYNC_DIR
+func sourcePath(path string) string {
+	rel, _ := filepath.Rel(syncDir, path)
+	return filepath.ToSlash(rel)
 }
 
-// autoSyncFromContent walks SYNC_DIR and imports markdown files into Mongo.
-// Uses UPSERT to re-import deleted files (from content/ directory only).
-// Directory naming convention: each first-level subdirectory under SYNC_DIR becomes a collection.
-// Files directly under SYNC_DIR (without subdirectory) go to collection "root".
-func autoSyncFromContent() error {
-	// Ensure base exists
-	if _, err := os.Stat(syncDir); err != nil {
-		return err
-	}
+// autoSyncFromContent imports every markdown file under SYNC_DIR into the store and
+// removes synced posts whose file is gone (content/ is the source of truth).
+func autoSyncFromContent() ([]SyncResult, error) {
+	return filesync.SyncAllFiles(filesync.SyncConfig{
+		RootDir:  syncDir,
+		Store:    store,
+		Pipeline: pipeline,
+		Prefix:   contentPrefix,
+		Prune:    true,
+	})
+}
 
-	// Walk syncDir and UPSERT all files (no duplicate check - content/ is source of truth)
-	return filepath.Walk(syncDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		// Only .md files
-		if !strings.HasSuffix(strings.ToLower(info.Name()), ".md") {
-			return nil
-		}
+// syncContentChanges applies only the files in a watcher batch to the store
+func syncContentChanges(batch watcher.Batch) []SyncResult {
+	var results []SyncResult
+	for _, path := range batch.Added {
+		results = append(results, syncContentFile(path, filesync.ActionAdded))
+	}
+	for _, path := range batch.Modified {
+		results = append(results, syncContentFile(path, filesync.ActionUpdated))
+	}
+	for _, path := range batch.Removed {
+		results = append(results, deletePostFromDB(path))
+	}
+	return results
+}
 
-		rel, _ := filepath.Rel(syncDir, path)
-		parts := strings.Split(rel, string(filepath.Separator))
-		var collectionName string
-		var filename string
-		if len(parts) == 1 {
-			collectionName = "content/root"
-			filename = parts[0]
-		} else {
-			// Prefix with "content/" to distinguish from uploaded collections
-			collectionName = "content/" + parts[0]
-			filename = strings.Join(parts[1:], "/")
-		}
+// syncContentFile reads one markdown file from SYNC_DIR and upserts it
+func syncContentFile(path string, action string) SyncResult {
+	collectionName, relPath, url, _, err := contentPostKey(path)
+	result := SyncResult{Path: path, Collection: collectionName, URL: url, Action: action}
+	if err != nil {
+		return result.Failed(err)
+	}
 
-		// Derive title/url
-		title := strings.TrimSuffix(filepath.Base(filename), ".md")
-		url := strings.ToLower(strings.ReplaceAll(title, " ", "-"))
-		url = strings.ReplaceAll(url, "_", "-")
-		isIndex := false
-		lowerName := strings.ToLower(filepath.Base(filename))
-		if lowerName == "index.md" || lowerName == "readme.md" {
-			isIndex = true
-			// Replace slashes with dashes for URL-friendly index slug
-			url = strings.ReplaceAll(collectionName, "/", "-") + "-index"
-		}
+	contentBytes, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return result.Failed(readErr)
+	}
 
-		contentBytes, readErr := ioutil.ReadFile(path)
-		if readErr != nil {
-			return readErr
-		}
-		body := string(contentBytes)
-		
-		// Process PlantUML diagrams with base-aware resolver (use original folder name without prefix)
-		baseDir := strings.TrimPrefix(collectionName, "content/")
-		body = plantuml.ProcessPlantUMLWithBase(body, baseDir)
-
-		post := db.Post{
-			Title:      title,
-			URL:        url,
-			Body:       body,
-			Collection: collectionName,
-			IsIndex:    isIndex,
-		}
-		
-		// Use UPSERT to re-import files deleted from GUI
-		if insErr := db.UpsertPost(collection, post, "124252"); insErr != nil {
-			log.Printf("Failed to upsert '%s' (%s): %v", title, path, insErr)
-		} else {
-			log.Printf("Synced '%s' -> collection '%s' (index=%v)", title, collectionName, isIndex)
-		}
-		return nil
+	post, err := pipeline.Ingest(ingest.File{
+		Path:       relPath,
+		Data:       contentBytes,
+		Origin:     db.SourceSync,
+		Collection: collectionName,
+		// PlantUML includes resolve against the original folder name without prefix
+		BaseDir: strings.TrimPrefix(collectionName, contentPrefix),
+		Source:  sourcePath(path),
+		Locate:  filesync.SyncConfig{RootDir: syncDir, Prefix: contentPrefix}.Locator(),
 	})
+	if err != nil {
+		return result.Failed(err)
+	}
+	
+	// Use UPSERT to re-import files deleted from GUI
+	if insErr := store.UpsertPost(post); insErr != nil {
+		log.Printf("Failed to upsert '%s' (%s): %v", post.Title, path, insErr)
+		return result.Failed(insErr)
+	}
+	log.Printf("Synced '%s' -> collection '%s' (index=%v)", post.Title, collectionName, post.IsIndex)
+	return result
 }
 
-// deletePostFromDB removes a post from database based on file path
-func deletePostFromDB(filePath string) error {
-	// Extract collection name from path: /app/content/{CollectionName}/...
-	relPath := strings.TrimPrefix(filePath, syncDir+"/")
-	parts := strings.Split(relPath, "/")
-	if len(parts) < 1 {
-		return fmt.Errorf("invalid file path: %s", filePath)
+// deletePostFromDB removes the post imported from a file under SYNC_DIR
+func deletePostFromDB(filePath string) SyncResult {
+	collectionName, _, url, isIndex, err := contentPostKey(filePath)
+	result := SyncResult{Path: filePath, Collection: collectionName, URL: url, Action: filesync.ActionDeleted}
+	if err != nil {
+		return result.Failed(err)
 	}
 	
-	// Prefix with "content/" to match auto-sync collection naming
-	collectionName := "content/" + parts[0]
-	fileName := filepath.Base(filePath)
+	log.Printf("DEBUG: Deleting post - collection=%s, url=%s, isIndex=%v, path=%s", collectionName, url, isIndex, filePath)
 	
-	// Check if this is an index file (README.md or index.md)
-	lowerFilename := strings.ToLower(fileName)
-	isIndex := (lowerFilename == "index.md" || lowerFilename == "readme.md")
+	if err := store.DeletePostByPath(collectionName, url); err != nil {
+		log.Printf("DEBUG: Delete failed: %v", err)
+		return result.Failed(err)
+	}
 	
-	var url string
-	if isIndex {
-		// For index files, URL is {collection}-index
-		url = collectionName + "-index"
-	} else {
-		// Create URL using same logic as filesync - convert path to slug
-		url = strings.TrimSuffix(relPath, ".md")
-		url = filepath.ToSlash(url)
-		url = strings.ToLower(url)
-		url = strings.ReplaceAll(url, " ", "-")
-		url = strings.ReplaceAll(url, "/", "-")
-		// Remove multiple consecutive dashes
-		for strings.Contains(url, "--") {
-			url = strings.ReplaceAll(url, "--", "-")
+	log.Printf("Successfully deleted post: %s/%s", collectionName, url)
+	return result
+}
+
+// postChange is a changed post as sent to SSE clients. Paths and errors stay on the server.
+type postChange struct {
+	Collection string `json:"collection"`
+	URL        string `json:"url"`
+	Action     string `json:"action"`
+}
+
+// broadcastPostChanges notifies SSE clients with the list of changed posts
+// they may read. The message is JSON: {"type":"reload","changes":[...postChange]}
+func broadcastPostChanges(results []SyncResult) {
+	changes := []postChange{}
+	for _, r := range results {
+		if r.Action != filesync.ActionUnchanged && r.Action != filesync.ActionFailed {
+			changes = append(changes, postChange{Collection: r.Collection, URL: r.URL, Action: r.Action})
 		}
 	}
+	if len(changes) == 0 {
+		return
+	}
 	
-	log.Printf("DEBUG: Deleting post - collection=%s, url=%s, isIndex=%v, path=%s", collectionName, url, isIndex, filePath)
-	
-	coll, err := db.ConnectToDB()
-	if err != nil {
-		return fmt.Errorf("DB connection failed: %v", err)
+	clientsMux.Lock()
+	requests := make(map[chan string]*http.Request, len(clients))
+	for client, r := range clients {
+		requests[client] = r
 	}
+	clientsMux.Unlock()
 	
-	err = db.DeletePostByPath(coll, collectionName, url)
-	if err != nil {
-		log.Printf("DEBUG: Delete failed: %v", err)
-		return err
+	// ACL lookups happen outside the lock; clients that left meanwhile are skipped below
+	messages := make(map[chan string]string, len(requests))
+	for client, r := range requests {
+		readable := readableFilter(r)
+		visible := []postChange{}
+		for _, c := range changes {
+			if readable(c.Collection) {
+				visible = append(visible, c)
+			}
+		}
+		if len(visible) == 0 {
+			continue
+		}
+		message, err := json.Marshal(map[string]interface{}{
+			"type":    "reload",
+			"changes": visible,
+		})
+		if err != nil {
+			message = []byte("reload")
+		}
+		messages[client] = string(message)
 	}
 	
-	log.Printf("Successfully deleted post: %s/%s", collectionName, url)
-	return nil
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+	for client, message := range messages {
+		if _, ok := clients[client]; ok {
+			sendToClient(client, message)
+		}
+	}
 }
 
 // broadcastChange sends reload notification to all connected SSE clients
@@ -236,11 +350,25 @@ func broadcastChange(message string) {
 	log.Printf("DEBUG: Broadcasting '%s' to %d connected clients", message, len(clients))
 	
 	for client := range clients {
-		select {
-		case client <- message:
-			log.Printf("DEBUG: Sent '%s' to client", message)
-		default:
-			log.Printf("DEBUG: Client blocked, skipping")
+		sendToClient(client, message)
+	}
+}
+
+// sendToClient queues a message for an SSE client unless its queue is full; callers must hold clientsMux
+func sendToClient(client chan string, message string) {
+	select {
+	case client <- message:
+		log.Printf("DEBUG: Sent '%s' to client", message)
+	default:
+		log.Printf("DEBUG: Client blocked, skipping")
+	}
+}
+
+// refreshSearchIndex periodically rebuilds the search index from the store
+func refreshSearchIndex(indexed *search.IndexedStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := indexed.Refresh(); err != nil {
+			log.Printf("Failed to refresh search index: %v", err)
 		}
 	}
 }
@@ -249,143 +377,102 @@ func broadcastChange(message string) {
 func watchContentDirectory() {
 	log.Printf("File watcher started for: %s", syncDir)
 	
-	// Track file hashes to detect actual content changes (not just timestamps)
-	fileHashes := make(map[string]string)
-	// Track existing collections (directories)
-	collectionDirs := make(map[string]bool)
-	
-	// Initial scan
-	filepath.Walk(syncDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
+	w := watcher.New(syncDir, watcher.Options{
+		Mode:         strings.ToLower(os.Getenv("WATCH_MODE")),
+		PollInterval: envDuration("WATCH_POLL_INTERVAL", 3*time.Second),
+		Rescan:       envDuration("WATCH_RESCAN", time.Minute),
+	})
+	w.Run(handleContentChanges)
+}
+
+// handleContentChanges applies one batch of filesystem changes to the store
+// and tells connected browsers which posts changed
+func handleContentChanges(batch watcher.Batch) {
+	var results []SyncResult
+	
+	// Check for deleted directories; each one is a collection with possible sub-collections
+	var deletedCollections []string
+	for _, dir := range batch.RemovedDirs {
+		relPath, err := filepath.Rel(syncDir, dir)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
 		}
+		// Prefix with "content/" to match collection naming in the store
+		fullCollectionName := contentPrefix + filepath.ToSlash(relPath)
+		log.Printf("Detected deleted collection: %s", fullCollectionName)
 		
-		// Track directories (collections)
-		if info.IsDir() && path != syncDir {
-			relPath, _ := filepath.Rel(syncDir, path)
-			parts := strings.Split(relPath, string(filepath.Separator))
-			if len(parts) >= 1 {
-				collectionDirs[parts[0]] = true
-			}
+		deleted, err := deleteCollectionTree(fullCollectionName)
+		for _, name := range deleted {
+			results = append(results, SyncResult{Path: dir, Collection: name, Action: filesync.ActionDeleted})
 		}
-		
-		// Track .md files
-		if !info.IsDir() && strings.HasSuffix(strings.ToLower(path), ".md") {
-			if hash, err := hashFile(path); err == nil {
-				fileHashes[path] = hash
-			}
+		if err != nil {
+			log.Printf("Failed to delete collection '%s': %v", fullCollectionName, err)
+			results = append(results, SyncResult{Path: dir, Collection: fullCollectionName}.Failed(err))
+			continue
 		}
-		return nil
-	})
+		log.Printf("Successfully deleted collection: %s", fullCollectionName)
+		deletedCollections = append(deletedCollections, fullCollectionName)
+	}
 	
-	log.Printf("DEBUG: Initial collections tracked: %v", collectionDirs)
+	// Posts of a deleted collection are already gone
+	var removed []string
+	for _, path := range batch.Removed {
+		if collectionName, _, _, _, err := contentPostKey(path); err == nil && inAnyCollection(collectionName, deletedCollections) {
+			continue
+		}
+		removed = append(removed, path)
+	}
+	batch.Removed = removed
 	
-	ticker := time.NewTicker(3 * time.Second)
-	defer ticker.Stop()
+	log.Printf("Changes detected: %d added, %d modified, %d removed", len(batch.Added), len(batch.Modified), len(batch.Removed))
+	results = append(results, syncContentChanges(batch)...)
 	
-	for range ticker.C {
-		changed := false
-		currentCollections := make(map[string]bool)
-		
-		// Scan directory for changes
-		filepath.Walk(syncDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return nil
-			}
-			
-			// Track current collections
-			if info.IsDir() && path != syncDir {
-				relPath, _ := filepath.Rel(syncDir, path)
-				parts := strings.Split(relPath, string(filepath.Separator))
-				if len(parts) >= 1 {
-					currentCollections[parts[0]] = true
-				}
-			}
-			
-			// Track .md files
-			if info.IsDir() {
-				return nil
-			}
-			
-			if !strings.HasSuffix(strings.ToLower(path), ".md") {
-				return nil
-			}
-			
-			currentHash, hashErr := hashFile(path)
-			if hashErr != nil {
-				return nil
-			}
-			
-			previousHash, exists := fileHashes[path]
-			if !exists || previousHash != currentHash {
-				changed = true
-				fileHashes[path] = currentHash
-				log.Printf("Detected change in: %s", path)
-			}
-			
-			return nil
-		})
-		
-		// Check for deleted collections (directories)
-		for collectionName := range collectionDirs {
-			if !currentCollections[collectionName] {
-				// Prefix with "content/" to match collection naming in MongoDB
-				fullCollectionName := "content/" + collectionName
-				log.Printf("Detected deleted collection: %s", fullCollectionName)
-				
-				// Delete entire collection from MongoDB
-				coll, err := db.ConnectToDB()
-				if err != nil {
-					log.Printf("Failed to connect to DB: %v", err)
-				} else {
-					if err := db.DeleteCollection(coll, fullCollectionName); err != nil {
-						log.Printf("Failed to delete collection '%s': %v", fullCollectionName, err)
-					} else {
-						log.Printf("Successfully deleted collection: %s", fullCollectionName)
-						changed = true
-					}
-				}
-			}
+	if len(results) > 0 {
+		broadcastPostChanges(results)
+	}
+}
+
+// deleteCollectionTree deletes a collection and all its sub-collections
+func deleteCollectionTree(name string) ([]string, error) {
+	collections, err := store.GetCollections()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, c := range collections {
+		if !filesync.InCollection(c, name) {
+			continue
 		}
-		
-		// Update tracked collections
-		collectionDirs = currentCollections
-		
-		// Remove deleted files from tracking and delete from DB
-		for path := range fileHashes {
-			if _, err := os.Stat(path); os.IsNotExist(err) {
-				delete(fileHashes, path)
-				log.Printf("Detected deletion: %s", path)
-				
-				// Delete from database
-				if err := deletePostFromDB(path); err != nil {
-					log.Printf("Failed to delete post from DB: %v", err)
-				}
-				
-				changed = true
-			}
+		if err := store.DeleteCollection(c); err != nil {
+			return deleted, err
 		}
-		
-		if changed {
-			log.Printf("Changes detected, triggering auto-sync...")
-			if err := autoSyncFromContent(); err != nil {
-				log.Printf("Auto-sync error: %v", err)
-			} else {
-				log.Printf("Auto-sync completed successfully")
-				broadcastChange("reload") // Notify browser to reload
-			}
+		deleted = append(deleted, c)
+	}
+	return deleted, nil
+}
+
+// inAnyCollection reports whether name is inside one of the collections
+func inAnyCollection(name string, collections []string) bool {
+	for _, c := range collections {
+		if filesync.InCollection(name, c) {
+			return true
 		}
 	}
+	return false
 }
 
-// hashFile computes MD5 hash of file content
-func hashFile(path string) (string, error) {
-	content, err := ioutil.ReadFile(path)
+// envDuration reads a duration such as "3s" from the environment
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		return "", err
+		log.Printf("Invalid %s=%q, using %v", name, value, fallback)
+		return fallback
 	}
-	hash := md5.Sum(content)
-	return hex.EncodeToString(hash[:]), nil
+	return d
 }
 
 // sseHandler handles Server-Sent Events for auto-refresh
@@ -403,7 +490,7 @@ func sseHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// Register client
 	clientsMux.Lock()
-	clients[messageChan] = true
+	clients[messageChan] = r
 	clientsMux.Unlock()
 	
 	// Unregister on disconnect
@@ -460,6 +547,30 @@ func plantUMLProxyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Diagrams encoded in the URL are served from the diagram cache when
+	// content added them, else rendered by the configured backend, which may
+	// not be a server. Anyone can send source here, so it is never cached.
+	if format, source, err := plantuml.ParseURL(r.URL.Path); err == nil {
+		if contentType, ok := diagram.Formats[format]; ok {
+			hash := diagram.Hash("plantuml", source)
+			if cache := diagram.DefaultCache(); cache != nil && cache.Has(hash) {
+				serveDiagram(w, r, cache, hash, format)
+				return
+			}
+			image, err := plantuml.Render(source, format)
+			if err != nil {
+				log.Printf("Failed to render diagram: %v", err)
+				http.Error(w, "Failed to render diagram", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			diagramSecurityHeaders(w)
+			w.Header().Set("Cache-Control", "public, max-age=86400") // Cache diagrams for 1 day
+			w.Write(image)
+			return
+		}
+	}
+	
 	// Forward to internal PlantUML server
 	plantUMLServer := os.Getenv("PLANTUML_SERVER")
 	if plantUMLServer == "" {
@@ -488,39 +599,187 @@ func plantUMLProxyHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// diagramSecurityHeaders keeps scripts in an SVG diagram from running when it
+// is opened directly rather than through <img>
+func diagramSecurityHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+}
+
+// diagramHandler serves a cached diagram by content address: /diagrams/{hash}.{format}
+func diagramHandler(w http.ResponseWriter, r *http.Request) {
+	cache := diagram.DefaultCache()
+	if cache == nil {
+		http.NotFound(w, r)
+		return
+	}
+	name := mux.Vars(r)["file"]
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	serveDiagram(w, r, cache, name[:dot], name[dot+1:])
+}
+
+// serveDiagram writes a cached diagram, rendering it on a cache miss. The
+// content never changes for a hash, so the ETag is strong and long lived.
+func serveDiagram(w http.ResponseWriter, r *http.Request, cache *diagram.Cache, hash string, format string) {
+	contentType, ok := diagram.Formats[format]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	etag := `"` + hash + "." + format + `"`
+	if notModified(r.Header.Get("If-None-Match"), etag, cache.Has(hash)) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	image, err := cache.Image(hash, format)
+	if err == diagram.ErrUnknownDiagram {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to render diagram %s.%s: %v", hash, format, err)
+		http.Error(w, "Failed to render diagram", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	diagramSecurityHeaders(w)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(image)
+}
+
+// notModified evaluates an If-None-Match header against etag. Tags are compared
+// whole with the weak comparison (W/"x" matches "x"); "*" matches when the
+// diagram exists.
+func notModified(header string, etag string, exists bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" && exists {
+			return true
+		}
+		if strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func configureRouter(r *mux.Router) {
+	// Mutating endpoints require a bearer token (see `goapp token create`)
+	writeAuth := auth.Require(store, auth.ScopeWrite)
+	adminAuth := auth.Require(store, auth.ScopeAdmin)
+	
 	r.HandleFunc("/", indexHandler)
-	r.HandleFunc("/post/{name}", mdNamedHandler)
-	r.HandleFunc("/add", addHandler)
+	r.HandleFunc("/post/{name}", legacyPostHandler) // Redirects to /content/{collection}/{url}
+	r.HandleFunc("/post/{name}/history", postHistoryHandler)
+	r.HandleFunc("/post/{name}/diff", postDiffHandler)
+	r.Handle("/add", writeAuth(http.HandlerFunc(addHandler)))
 	r.HandleFunc("/collections", collectionsHandler)
+	r.HandleFunc("/api/tree", treeHandler).Methods("GET")
+	r.HandleFunc("/highlight.css", highlightCSSHandler).Methods("GET")
+	r.HandleFunc("/api/links/broken", brokenLinksHandler).Methods("GET")
 	// REMOVED: /collection/{collection} - replaced by /content/{collection...}
 	r.HandleFunc("/content/{collection:.*}", collectionContentHandler) // Match everything after /content/
 	
 	// Collection management endpoints
-	r.HandleFunc("/api/collection/create", createCollectionHandler).Methods("POST")
-	r.HandleFunc("/api/collection/{name:.*}/delete", deleteCollectionHandler).Methods("DELETE")
-	r.HandleFunc("/api/collection/{name:.*}/upload", uploadFilesHandler).Methods("POST")
+	r.Handle("/api/collection/create", writeAuth(http.HandlerFunc(createCollectionHandler))).Methods("POST")
+	r.Handle("/api/collection/{name:.*}/delete", adminAuth(http.HandlerFunc(deleteCollectionHandler))).Methods("DELETE")
+	r.Handle("/api/collection/{name:.*}/upload", writeAuth(http.HandlerFunc(uploadFilesHandler))).Methods("POST")
+	r.Handle("/api/collection/{name:.*}/acl", adminAuth(http.HandlerFunc(getCollectionACLHandler))).Methods("GET")
+	r.Handle("/api/collection/{name:.*}/acl", adminAuth(http.HandlerFunc(setCollectionACLHandler))).Methods("PUT")
 	r.HandleFunc("/api/collection/{name:.*}", getCollectionPostsHandler).Methods("GET")
 	
+	// Post revision history
+	r.HandleFunc("/api/post/{url}/history", postHistoryAPIHandler).Methods("GET")
+	r.HandleFunc("/api/post/{url}/diff", postDiffAPIHandler).Methods("GET")
+	r.HandleFunc("/api/post/{url}/backlinks", backlinksAPIHandler).Methods("GET")
+	r.HandleFunc("/api/post/{url}/toc", tocAPIHandler).Methods("GET")
+	
+	// Full-text search
+	r.HandleFunc("/api/search", searchHandler).Methods("GET")
+	
+	// Tag taxonomy
+	r.HandleFunc("/tags", tagsHandler)
+	r.HandleFunc("/tags/{tag}", tagHandler)
+	r.HandleFunc("/api/tags", tagsAPIHandler).Methods("GET")
+	r.HandleFunc("/api/tags/{tag}", tagPostsAPIHandler).Methods("GET")
+	
 	// File sync endpoint
-	r.HandleFunc("/api/sync", syncDirectoryHandler).Methods("POST", "GET")
+	r.Handle("/api/sync", writeAuth(http.HandlerFunc(syncDirectoryHandler))).Methods("POST", "GET")
 	
 	// SSE endpoint for auto-refresh
 	r.HandleFunc("/api/events", sseHandler)
 	
+	// Cached diagrams, addressed by a hash of their source
+	r.HandleFunc("/diagrams/{file}", diagramHandler).Methods("GET")
 	// PlantUML proxy to avoid CSRF warnings
 	r.PathPrefix("/plantuml/").HandlerFunc(plantUMLProxyHandler)
 	
 	// Add security headers middleware
 	r.Use(securityHeadersMiddleware)
+	// Attach the caller's token (if any) so read handlers can enforce collection ACLs
+	r.Use(auth.Optional(store))
+}
+
+// renderMarkdown converts markdown of a server page to HTML with the configured
+// renderer and sanitizes it with the server page policy
+func renderMarkdown(text string) template.HTML {
+	output, _ := renderDocument(text, "", "")
+	return output
+}
+
+// renderDocument renders markdown from a post with the given collection and
+// source, sanitized with that post's policy, and returns the heading outline.
+// Heading ids and the [[toc]] placeholder are filled in after sanitizing.
+func renderDocument(text string, collection, source string) (template.HTML, []toc.Entry) {
+	// Diagrams are already images here; the remaining fences get highlighted
+	text = highlighter.Markdown(text)
+	out, err := renderer.Render([]byte(text))
+	if err != nil {
+		log.Printf("Failed to render markdown: %v", err)
+		return template.HTML("<pre>" + template.HTMLEscapeString(text) + "</pre>"), nil
+	}
+	out = sanitizer.Sanitize(collection, source, out)
+	withIDs, outline, err := toc.Process(out)
+	if err != nil {
+		log.Printf("Failed to generate table of contents: %v", err)
+		return template.HTML(out), nil
+	}
+	return template.HTML(withIDs), outline
+}
+
+// sidebarTOC renders the outline for the sidebar slot; short documents get none
+func sidebarTOC(outline []toc.Entry) template.HTML {
+	visible := toc.Visible(outline)
+	if toc.Count(visible) < 2 {
+		return ""
+	}
+	return template.HTML(toc.HTML(visible))
+}
+
+// highlightCSSHandler serves the code highlighting theme (?style= picks another chroma style)
+func highlightCSSHandler(w http.ResponseWriter, r *http.Request) {
+	css, err := highlighter.CSS(r.URL.Query().Get("style"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(css)
 }
 
 func errorNotFoundPage(w http.ResponseWriter) {
 	text := `# Error 404
 This page not found`
 	tmpl := template.Must(template.ParseFiles("content.html"))
-	output := template.HTML(string(blackfriday.Run([]byte(text))))
-	tmpl.ExecuteTemplate(w, "content", output)
+	output := renderMarkdown(text)
+	w.WriteHeader(http.StatusNotFound)
+	tmpl.ExecuteTemplate(w, "content", contentPage{Content: output})
 }
 
 func internalServerErrorPage(err error, w http.ResponseWriter) {
@@ -528,6 +787,6 @@ func internalServerErrorPage(err error, w http.ResponseWriter) {
 	text := `# Error 500
 Internal server error`
 	tmpl := template.Must(template.ParseFiles("content.html"))
-	output := template.HTML(string(blackfriday.Run([]byte(text))))
-	tmpl.ExecuteTemplate(w, "content", output)
+	output := renderMarkdown(text)
+	tmpl.ExecuteTemplate(w, "content", contentPage{Content: output})
 }
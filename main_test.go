@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc.svg"`
+	tests := []struct {
+		header string
+		exists bool
+		want   bool
+	}{
+		{``, true, false},
+		{`"abc.svg"`, false, true},
+		{`W/"abc.svg"`, true, true},
+		{`"x.png", "abc.svg"`, true, true},
+		{`"x.png",W/"abc.svg"`, true, true},
+		{`"abc.svg.old"`, true, false},
+		{`"xabc.svg"`, true, false},
+		{`*`, true, true},
+		{`*`, false, false},
+	}
+	for _, tt := range tests {
+		if got := notModified(tt.header, etag, tt.exists); got != tt.want {
+			t.Errorf("If-None-Match %s (exists %v): %v, want %v", tt.header, tt.exists, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package plantuml
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beldmian/go-markdown-server/diagram"
+)
+
+// Backend names accepted by PLANTUML_BACKEND
+const (
+	BackendServer = "server"
+	BackendJar    = "jar"
+)
+
+// PlantUML security profiles accepted by PLANTUML_SECURITY_PROFILE, from
+// least to most permissive. SANDBOX forbids reading files and URLs, INTERNET
+// allows http(s) URLs but no local files.
+var securityProfiles = []string{"SANDBOX", "ALLOWLIST", "INTERNET", "LEGACY", "UNSECURE"}
+
+// DefaultSecurityProfile keeps diagram source, which anyone can send through
+// the /plantuml/ proxy, from reading local files or internal URLs with !include
+const DefaultSecurityProfile = "SANDBOX"
+
+// DefaultTimeout bounds a single render, including time spent queued
+const DefaultTimeout = diagram.DefaultTimeout
+
+// ErrTimeout is returned when a diagram does not render within the timeout
+var ErrTimeout = diagram.ErrTimeout
+
+// Backend renders diagram source into an image format (png or svg)
+type Backend interface {
+	Render(source string, format string) ([]byte, error)
+}
+
+// ServerBackend renders through a PlantUML server (plantuml/plantuml-server)
+type ServerBackend struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewServerBackend creates a backend for the server at url
+func NewServerBackend(url string, timeout time.Duration) *ServerBackend {
+	return &ServerBackend{URL: strings.TrimSuffix(url, "/"), Client: &http.Client{Timeout: timeout}}
+}
+
+// Render fetches the diagram from the server
+func (b *ServerBackend) Render(source string, format string) ([]byte, error) {
+	// Use internal URL for fetching
+	url := fmt.Sprintf("%s/%s/%s", b.URL, format, Encode(source))
+
+	resp, err := b.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to generate diagram: HTTP %d", resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// JarBackend renders with a local plantuml.jar (java -jar plantuml.jar -pipe).
+// A worker pool runs at most Workers java processes at a time.
+type JarBackend struct {
+	Java string
+	Jar  string
+	// SecurityProfile is passed as PLANTUML_SECURITY_PROFILE; see securityProfiles
+	SecurityProfile string
+
+	pool *diagram.Pool
+}
+
+// NewJarBackend starts workers rendering with java and jar
+func NewJarBackend(java, jar string, workers int, timeout time.Duration) (*JarBackend, error) {
+	if _, err := os.Stat(jar); err != nil {
+		return nil, fmt.Errorf("plantuml.jar not found: %v", err)
+	}
+	if _, err := exec.LookPath(java); err != nil {
+		return nil, fmt.Errorf("java not found: %v", err)
+	}
+	return &JarBackend{Java: java, Jar: jar, SecurityProfile: DefaultSecurityProfile, pool: diagram.NewPool(workers, timeout)}, nil
+}
+
+// Render queues the diagram for a worker and waits at most the timeout for the image
+func (b *JarBackend) Render(source string, format string) ([]byte, error) {
+	return b.pool.Run(func(ctx context.Context) ([]byte, error) {
+		return diagram.Exec(ctx, source, b.Java, "-Djava.awt.headless=true",
+			"-DPLANTUML_SECURITY_PROFILE="+b.SecurityProfile, "-jar", b.Jar,
+			"-pipe", "-t"+format, "-charset", "UTF-8")
+	})
+}
+
+// backendFromEnv creates the backend selected by PLANTUML_BACKEND (server or
+// jar). The jar backend reads PLANTUML_JAR, PLANTUML_JAVA, PLANTUML_WORKERS and
+// PLANTUML_SECURITY_PROFILE; both use PLANTUML_TIMEOUT.
+func backendFromEnv() (Backend, error) {
+	timeout := DefaultTimeout
+	if v := os.Getenv("PLANTUML_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid PLANTUML_TIMEOUT: %s", v)
+		}
+		timeout = d
+	}
+	switch name := strings.ToLower(os.Getenv("PLANTUML_BACKEND")); name {
+	case "", BackendServer:
+		return NewServerBackend(plantUMLServerURL, timeout), nil
+	case BackendJar:
+		jar := os.Getenv("PLANTUML_JAR")
+		if jar == "" {
+			jar = "plantuml.jar"
+		}
+		java := os.Getenv("PLANTUML_JAVA")
+		if java == "" {
+			java = "java"
+		}
+		workers := runtime.NumCPU()
+		if v := os.Getenv("PLANTUML_WORKERS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid PLANTUML_WORKERS: %s", v)
+			}
+			workers = n
+		}
+		profile := strings.ToUpper(os.Getenv("PLANTUML_SECURITY_PROFILE"))
+		if profile == "" {
+			profile = DefaultSecurityProfile
+		}
+		if !validProfile(profile) {
+			return nil, fmt.Errorf("unknown PLANTUML_SECURITY_PROFILE: %s (use %s)", profile, strings.Join(securityProfiles, ", "))
+		}
+		log.Printf("PlantUML backend: %s with %s (%d workers, %v timeout, %s profile)", jar, java, workers, timeout, profile)
+		b, err := NewJarBackend(java, jar, workers, timeout)
+		if err != nil {
+			return nil, err
+		}
+		b.SecurityProfile = profile
+		return b, nil
+	default:
+		return nil, fmt.Errorf("unknown PLANTUML_BACKEND: %s (use server or jar)", name)
+	}
+}
+
+// validProfile reports whether profile is a PlantUML security profile
+func validProfile(profile string) bool {
+	for _, p := range securityProfiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package plantuml
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeJava writes a java stand-in that prints its arguments, and an empty jar
+func fakeJava(t *testing.T, dir string) (java string, jar string) {
+	java = filepath.Join(dir, "java")
+	if err := ioutil.WriteFile(java, []byte("#!/bin/sh\ncat > /dev/null\necho \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	jar = filepath.Join(dir, "plantuml.jar")
+	if err := ioutil.WriteFile(jar, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return java, jar
+}
+
+func TestJarBackendSecurityProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plantuml-jar-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	java, jar := fakeJava(t, dir)
+
+	b, err := NewJarBackend(java, jar, 1, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, profile := range []string{"", "INTERNET"} {
+		want := DefaultSecurityProfile
+		if profile != "" {
+			b.SecurityProfile, want = profile, profile
+		}
+		args, err := b.Render("@startuml\n!include /etc/passwd\n@enduml", "svg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(args), "-DPLANTUML_SECURITY_PROFILE="+want+" ") {
+			t.Errorf("java ran with %q, want the %s security profile", args, want)
+		}
+	}
+}
+
+func TestBackendFromEnvRejectsUnknownProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plantuml-jar-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	java, jar := fakeJava(t, dir)
+
+	for key, value := range map[string]string{
+		"PLANTUML_BACKEND":          BackendJar,
+		"PLANTUML_JAVA":             java,
+		"PLANTUML_JAR":              jar,
+		"PLANTUML_SECURITY_PROFILE": "internet",
+	} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+	b, err := backendFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile := b.(*JarBackend).SecurityProfile; profile != "INTERNET" {
+		t.Errorf("security profile = %s, want INTERNET", profile)
+	}
+
+	os.Setenv("PLANTUML_SECURITY_PROFILE", "NONE")
+	if _, err := backendFromEnv(); err == nil {
+		t.Errorf("unknown security profile accepted")
+	}
+}
@@ -0,0 +1,134 @@
+package plantuml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// alphabet is PlantUML's base64 variant: 0-9A-Za-z-_ in place of A-Za-z0-9+/
+const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_"
+
+// Prefixes PlantUML servers recognize in front of encoded diagram text
+const (
+	// hexPrefix marks plain hex encoded (uncompressed) text
+	hexPrefix = "~h"
+	// brotliPrefix marks brotli compressed text. It is not a hex mode despite
+	// its look; Decode rejects it because the standard library has no brotli
+	// decoder, and Encode never produces it.
+	brotliPrefix = "~1"
+)
+
+// ErrUnsupportedEncoding is returned by Decode for encodings it cannot read
+var ErrUnsupportedEncoding = errors.New("unsupported PlantUML encoding")
+
+// decodeTable maps alphabet characters back to their 6-bit values (-1 if invalid)
+var decodeTable = func() [256]int {
+	var table [256]int
+	for i := range table {
+		table[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		table[alphabet[i]] = i
+	}
+	return table
+}()
+
+// Encode returns the text encoding PlantUML servers expect in diagram URLs:
+// raw deflate compression followed by PlantUML's base64 variant
+func Encode(text string) string {
+	var compressed bytes.Buffer
+	w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	w.Write([]byte(text))
+	w.Close()
+	return encode64(compressed.Bytes())
+}
+
+// EncodeHex returns the uncompressed "~h" hex encoding of text. URLs are
+// longer, but readable and easy to produce from other tools.
+func EncodeHex(text string) string {
+	return hexPrefix + hex.EncodeToString([]byte(text))
+}
+
+// Decode reverses Encode and EncodeHex. The only prefixed mode it reads is
+// "~h"; "~1" (brotli) and other modes return ErrUnsupportedEncoding.
+func Decode(encoded string) (string, error) {
+	switch {
+	case strings.HasPrefix(encoded, hexPrefix):
+		data, err := hex.DecodeString(encoded[len(hexPrefix):])
+		if err != nil {
+			return "", fmt.Errorf("invalid PlantUML hex encoding: %v", err)
+		}
+		return string(data), nil
+	case strings.HasPrefix(encoded, brotliPrefix):
+		return "", fmt.Errorf("%w: brotli (%s)", ErrUnsupportedEncoding, brotliPrefix)
+	case strings.HasPrefix(encoded, "~"):
+		return "", fmt.Errorf("%w: %.2s", ErrUnsupportedEncoding, encoded)
+	}
+	compressed, err := decode64(encoded)
+	if err != nil {
+		return "", err
+	}
+	text, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return "", fmt.Errorf("invalid PlantUML encoding: %v", err)
+	}
+	return string(text), nil
+}
+
+// ParseURL decodes a diagram URL such as /plantuml/png/SyfFKj2rKt3CoKnELR1Io4ZDoSa70000
+// (absolute or relative, through the proxy or the server) into its output
+// format and diagram text
+func ParseURL(rawURL string) (format string, text string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("not a PlantUML diagram URL: %s", rawURL)
+	}
+	format = parts[len(parts)-2]
+	text, err = Decode(parts[len(parts)-1])
+	return format, text, err
+}
+
+// encode64 encodes data in PlantUML's base64 variant, three bytes to four
+// characters with the last group zero padded, like PlantUML's own encoder
+func encode64(data []byte) string {
+	var result strings.Builder
+	for i := 0; i < len(data); i += 3 {
+		var group [3]byte
+		copy(group[:], data[i:])
+		b := uint32(group[0])<<16 | uint32(group[1])<<8 | uint32(group[2])
+		for shift := 18; shift >= 0; shift -= 6 {
+			result.WriteByte(alphabet[(b>>uint(shift))&0x3F])
+		}
+	}
+	return result.String()
+}
+
+// decode64 reverses encode64; a trailing partial group is accepted
+func decode64(encoded string) ([]byte, error) {
+	var out []byte
+	var bits uint32
+	var bitsLen uint
+	for i := 0; i < len(encoded); i++ {
+		v := decodeTable[encoded[i]]
+		if v < 0 {
+			return nil, fmt.Errorf("invalid PlantUML encoding: unexpected %q at %d", encoded[i], i)
+		}
+		bits = bits<<6 | uint32(v)
+		bitsLen += 6
+		if bitsLen >= 8 {
+			bitsLen -= 8
+			out = append(out, byte(bits>>bitsLen))
+		}
+	}
+	return out, nil
+}
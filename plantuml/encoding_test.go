@@ -0,0 +1,115 @@
+package plantuml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// roundTrips are diagram texts Encode and EncodeHex must reproduce exactly
+var roundTrips = []string{
+	"",
+	"Bob -> Alice : hello",
+	"@startuml\nBob -> Alice : hello\n@enduml",
+	"@startuml\nАлиса -> Боб : привет\n日本 -> 中国 : こんにちは 🎉\n@enduml",
+	"a", "ab", "abc", "abcd", // every length of the last three byte group
+	strings.Repeat("@startuml\nclass A\nA --> B : uses\n@enduml\n", 200),
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, text := range roundTrips {
+		encoded := Encode(text)
+		if strings.Trim(encoded, alphabet) != "" {
+			t.Errorf("Encode(%.20q) = %q uses characters outside the PlantUML alphabet", text, encoded)
+		}
+		if len(encoded)%4 != 0 {
+			t.Errorf("Encode(%.20q) = %q is not made of whole four character groups", text, encoded)
+		}
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Errorf("Decode(Encode(%.20q)): %v", text, err)
+			continue
+		}
+		if decoded != text {
+			t.Errorf("Decode(Encode(%.20q)) = %.20q", text, decoded)
+		}
+	}
+}
+
+func TestEncodeHexRoundTrip(t *testing.T) {
+	for _, text := range roundTrips {
+		encoded := EncodeHex(text)
+		if !strings.HasPrefix(encoded, "~h") {
+			t.Errorf("EncodeHex(%.20q) = %q lacks the ~h prefix", text, encoded)
+		}
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Errorf("Decode(EncodeHex(%.20q)): %v", text, err)
+			continue
+		}
+		if decoded != text {
+			t.Errorf("Decode(EncodeHex(%.20q)) = %.20q", text, decoded)
+		}
+	}
+}
+
+func TestDecodeServerVectors(t *testing.T) {
+	// Encoded by PlantUML itself; Java's deflate output differs from Go's, so
+	// these are checked by decoding rather than by comparing Encode output
+	vectors := map[string]string{
+		"SyfFKj2rKt3CoKnELR1Io4ZDoSa70000": "Bob -> Alice : hello",
+		"~h407374617274756d6c0a416c6963652d3e426f62203a204920616d207573696e67206865780a40656e64756d6c": "@startuml\nAlice->Bob : I am using hex\n@enduml",
+	}
+	for encoded, want := range vectors {
+		got, err := Decode(encoded)
+		if err != nil {
+			t.Errorf("Decode(%q): %v", encoded, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Decode(%q) = %q, want %q", encoded, got, want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, encoded := range []string{
+		"~1UDgKAIAIAtB",     // brotli
+		"~zabc",             // unknown mode
+		"~hnot-hex",         // invalid hex
+		"SyfF*j2rKt3CoKnE",  // outside the alphabet
+		"SyfFKj2rKt3CoKnEL", // truncated deflate stream
+	} {
+		if _, err := Decode(encoded); err == nil {
+			t.Errorf("Decode(%q) succeeded, want an error", encoded)
+		}
+	}
+	if _, err := Decode("~1UDgKAIAIAtB"); !errors.Is(err, ErrUnsupportedEncoding) {
+		t.Errorf("Decode of brotli text = %v, want ErrUnsupportedEncoding", err)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url, format, text string
+	}{
+		{"https://www.plantuml.com/plantuml/png/SyfFKj2rKt3CoKnELR1Io4ZDoSa70000", "png", "Bob -> Alice : hello"},
+		{"http://plantuml:8080/svg/SyfFKj2rKt3CoKnELR1Io4ZDoSa70000", "svg", "Bob -> Alice : hello"},
+		{"/plantuml/png/SyfFKj2rKt3CoKnELR1Io4ZDoSa70000", "png", "Bob -> Alice : hello"},
+		{"/plantuml/txt/~h426f62202d3e20416c696365", "txt", "Bob -> Alice"},
+		{"/plantuml/svg/" + Encode("Ünïcode -> ✓"), "svg", "Ünïcode -> ✓"},
+	}
+	for _, tt := range tests {
+		format, text, err := ParseURL(tt.url)
+		if err != nil {
+			t.Errorf("ParseURL(%q): %v", tt.url, err)
+			continue
+		}
+		if format != tt.format || text != tt.text {
+			t.Errorf("ParseURL(%q) = %q, %q, want %q, %q", tt.url, format, text, tt.format, tt.text)
+		}
+	}
+	if _, _, err := ParseURL("/SyfFKj2rKt3CoKnELR1Io4ZDoSa70000"); err == nil {
+		t.Errorf("ParseURL without a format succeeded")
+	}
+}
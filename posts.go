@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/filesync"
+	"github.com/beldmian/go-markdown-server/ingest"
+	"github.com/gorilla/mux"
+)
+
+// errAmbiguousPost is returned when a bare url matches posts in several collections
+var errAmbiguousPost = errors.New("url matches posts in more than one collection")
+
+// postPath returns the canonical URL of a post: /content/{collection}/{url}
+func postPath(post db.Post) string {
+	return ingest.PostPath(post.Collection, post.URL)
+}
+
+// postHistoryPath returns the revision history page of a post
+func postHistoryPath(post db.Post) string {
+	return "/post/" + url.PathEscape(post.URL) + "/history?collection=" + url.QueryEscape(post.Collection)
+}
+
+// postDiffPath returns the revision diff page of a post
+func postDiffPath(post db.Post) string {
+	return "/post/" + url.PathEscape(post.URL) + "/diff?collection=" + url.QueryEscape(post.Collection)
+}
+
+// lookupContentPost resolves a /content/ path that is not a collection to a post.
+// Collections win, so a post named like a sibling directory is only reachable
+// through its legacy /post/ URL.
+func lookupContentPost(name string) (db.Post, bool) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return db.Post{}, false
+	}
+	collections, err := store.GetCollections()
+	if err != nil {
+		return db.Post{}, false
+	}
+	for _, c := range collections {
+		if filesync.InCollection(c, name) {
+			return db.Post{}, false
+		}
+	}
+	post, err := store.GetPost(name[:i], name[i+1:])
+	return post, err == nil
+}
+
+// findPost resolves a post by url, optionally narrowed to one collection.
+// Without a collection the url must be unique among the readable posts.
+func findPost(r *http.Request, collectionName string, postURL string) (db.Post, error) {
+	if collectionName != "" {
+		post, err := store.GetPost(collectionName, postURL)
+		if err != nil || !canReadCollection(r, post.Collection) {
+			return db.Post{}, db.ErrNotFound
+		}
+		return post, nil
+	}
+	matches, err := readablePostsByURL(r, postURL)
+	if err != nil {
+		return db.Post{}, err
+	}
+	switch len(matches) {
+	case 0:
+		return db.Post{}, db.ErrNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return db.Post{}, errAmbiguousPost
+	}
+}
+
+// readablePostsByURL returns the posts with a url in collections the caller may read
+func readablePostsByURL(r *http.Request, postURL string) ([]db.Post, error) {
+	posts, err := store.GetPostsByURL(postURL)
+	if err != nil {
+		return nil, err
+	}
+	readable := readableFilter(r)
+	visible := []db.Post{}
+	for _, post := range posts {
+		if readable(post.Collection) {
+			visible = append(visible, post)
+		}
+	}
+	return visible, nil
+}
+
+// legacyPostHandler redirects /post/{name} to the canonical URL when the name
+// is unambiguous and lists the candidates otherwise
+func legacyPostHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	matches, err := readablePostsByURL(r, name)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	switch len(matches) {
+	case 0:
+		errorNotFoundPage(w)
+	case 1:
+		target := postPath(matches[0])
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	default:
+		var out strings.Builder
+		fmt.Fprintf(&out, "# %s\n\nThis page exists in more than one collection:\n\n", html.EscapeString(name))
+		for _, post := range matches {
+			fmt.Fprintf(&out, "- [%s](%s) in %s\n", post.Title, postPath(post), html.EscapeString(post.Collection))
+		}
+		w.WriteHeader(http.StatusMultipleChoices)
+		renderMarkdownPage(w, out.String())
+	}
+}
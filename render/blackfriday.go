@@ -0,0 +1,34 @@
+package render
+
+import "github.com/russross/blackfriday"
+
+// Blackfriday is the original renderer, kept for documents that rely on its quirks
+type Blackfriday struct {
+	extensions blackfriday.Extensions
+}
+
+// newBlackfriday maps the enabled extensions onto blackfriday flags
+func newBlackfriday(exts map[string]bool) *Blackfriday {
+	flags := blackfriday.NoIntraEmphasis | blackfriday.FencedCode | blackfriday.SpaceHeadings |
+		blackfriday.HeadingIDs | blackfriday.BackslashLineBreak
+	mapping := map[string]blackfriday.Extensions{
+		ExtTable:          blackfriday.Tables,
+		ExtStrikethrough:  blackfriday.Strikethrough,
+		ExtLinkify:        blackfriday.Autolink,
+		ExtFootnote:       blackfriday.Footnotes,
+		ExtDefinitionList: blackfriday.DefinitionLists,
+		ExtHardWraps:      blackfriday.HardLineBreak,
+	}
+	for name, flag := range mapping {
+		if exts[name] {
+			flags |= flag
+		}
+	}
+	// Task lists and typographer options have no blackfriday equivalent
+	return &Blackfriday{extensions: flags}
+}
+
+// Render ...
+func (b *Blackfriday) Render(markdown []byte) ([]byte, error) {
+	return blackfriday.Run(markdown, blackfriday.WithExtensions(b.extensions)), nil
+}
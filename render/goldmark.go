@@ -0,0 +1,58 @@
+package render
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// Goldmark is a CommonMark compliant renderer with GitHub Flavored Markdown extensions
+type Goldmark struct {
+	md goldmark.Markdown
+}
+
+// newGoldmark configures goldmark with the enabled extensions
+func newGoldmark(exts map[string]bool) *Goldmark {
+	extenders := []struct {
+		name     string
+		extender goldmark.Extender
+	}{
+		{ExtTable, extension.Table},
+		{ExtStrikethrough, extension.Strikethrough},
+		{ExtLinkify, extension.Linkify},
+		{ExtTaskList, extension.TaskList},
+		{ExtFootnote, extension.Footnote},
+		{ExtDefinitionList, extension.DefinitionList},
+		{ExtTypographer, extension.Typographer},
+	}
+	var enabled []goldmark.Extender
+	for _, e := range extenders {
+		if exts[e.name] {
+			enabled = append(enabled, e.extender)
+		}
+	}
+
+	// Raw HTML is passed through like blackfriday does; {#id} sets heading ids
+	htmlOpts := []renderer.Option{html.WithUnsafe()}
+	if exts[ExtHardWraps] {
+		htmlOpts = append(htmlOpts, html.WithHardWraps())
+	}
+	return &Goldmark{md: goldmark.New(
+		goldmark.WithExtensions(enabled...),
+		goldmark.WithParserOptions(parser.WithAttribute()),
+		goldmark.WithRendererOptions(htmlOpts...),
+	)}
+}
+
+// Render ...
+func (g *Goldmark) Render(markdown []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := g.md.Convert(markdown, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Renderer names accepted by New and MARKDOWN_RENDERER
+const (
+	NameGoldmark    = "goldmark"
+	NameBlackfriday = "blackfriday"
+)
+
+// Extension names accepted in Options.Extensions and MARKDOWN_EXTENSIONS
+const (
+	ExtTable          = "table"
+	ExtStrikethrough  = "strikethrough"
+	ExtLinkify        = "linkify"
+	ExtTaskList       = "tasklist"
+	ExtFootnote       = "footnote"
+	ExtDefinitionList = "definitionlist"
+	ExtTypographer    = "typographer"
+	ExtHardWraps      = "hardwraps"
+	// ExtGFM expands to the GitHub Flavored Markdown extensions
+	ExtGFM = "gfm"
+)
+
+// DefaultExtensions are used when none are configured
+var DefaultExtensions = []string{ExtGFM, ExtFootnote, ExtDefinitionList}
+
+// gfmExtensions are the extensions ExtGFM stands for
+var gfmExtensions = []string{ExtTable, ExtStrikethrough, ExtLinkify, ExtTaskList}
+
+// Renderer turns markdown into HTML
+type Renderer interface {
+	Render(markdown []byte) ([]byte, error)
+}
+
+// Options configures a renderer
+type Options struct {
+	// Extensions to enable; nil uses DefaultExtensions
+	Extensions []string
+}
+
+// New creates the named renderer ("" selects goldmark)
+func New(name string, opts Options) (Renderer, error) {
+	if opts.Extensions == nil {
+		opts.Extensions = DefaultExtensions
+	}
+	exts, err := expand(opts.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(name) {
+	case "", NameGoldmark:
+		return newGoldmark(exts), nil
+	case NameBlackfriday:
+		return newBlackfriday(exts), nil
+	default:
+		return nil, fmt.Errorf("unknown markdown renderer: %s", name)
+	}
+}
+
+// FromEnv creates the renderer selected by MARKDOWN_RENDERER with the
+// comma separated MARKDOWN_EXTENSIONS (e.g. "gfm,footnote")
+func FromEnv() (Renderer, error) {
+	var opts Options
+	if list := os.Getenv("MARKDOWN_EXTENSIONS"); list != "" {
+		opts.Extensions = []string{}
+		for _, ext := range strings.Split(list, ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				opts.Extensions = append(opts.Extensions, ext)
+			}
+		}
+	}
+	return New(os.Getenv("MARKDOWN_RENDERER"), opts)
+}
+
+// expand resolves ExtGFM and validates extension names
+func expand(names []string) (map[string]bool, error) {
+	exts := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		switch name {
+		case ExtGFM:
+			for _, ext := range gfmExtensions {
+				exts[ext] = true
+			}
+		case ExtTable, ExtStrikethrough, ExtLinkify, ExtTaskList, ExtFootnote, ExtDefinitionList, ExtTypographer, ExtHardWraps:
+			exts[name] = true
+		default:
+			return nil, fmt.Errorf("unknown markdown extension: %s", name)
+		}
+	}
+	return exts, nil
+}
@@ -8,12 +8,17 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
+	"github.com/beldmian/go-markdown-server/auth"
 	"github.com/beldmian/go-markdown-server/db"
-	"github.com/beldmian/go-markdown-server/plantuml"
+	"github.com/beldmian/go-markdown-server/diagram"
+	"github.com/beldmian/go-markdown-server/filesync"
+	"github.com/beldmian/go-markdown-server/ingest"
+	"github.com/beldmian/go-markdown-server/toc"
 	"github.com/gorilla/mux"
-	"github.com/russross/blackfriday"
 )
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
@@ -28,34 +33,44 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func mdNamedHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	post, err := db.GetPostByName(collection, vars["name"])
-	if err != nil {
-		errorNotFoundPage(w)
-		return
-	}
+// renderPost renders a single post into content.html
+func renderPost(w http.ResponseWriter, r *http.Request, post db.Post) {
+	processedBody := prepareMarkdown(post.Body, post.Collection, post.Source)
+	processedBody += "\n\n---\n[History](" + postHistoryPath(post) + ")\n"
 	
-	// Process PlantUML blocks and convert cross-references
-	processedBody := post.Body
+	// Use content.html (only content, no sidebar) for iframe display
+	tmpl := template.Must(template.ParseFiles("content.html"))
+	output, outline := renderDocument(processedBody, post.Collection, post.Source)
+	page := contentPage{Content: output, Post: &post, TOC: sidebarTOC(outline)}
+	if links, err := visibleBacklinks(r, post); err == nil {
+		page.Backlinks = links
+	} else {
+		log.Printf("Failed to load backlinks of %s/%s: %v", post.Collection, post.URL, err)
+	}
+	tmpl.ExecuteTemplate(w, "content", page)
+}
+
+// prepareMarkdown processes diagram blocks and cross-references of markdown
+// from a post with the given collection and source before it is rendered
+func prepareMarkdown(body string, collection, source string) string {
 	// For uploaded collections, use empty baseDir (inline blocks work, .puml files won't be found)
 	// For content/ collections, use actual baseDir for .puml file resolution
 	baseDir := ""
-	if !strings.HasPrefix(post.Collection, "uploaded/") {
-		baseDir = strings.TrimPrefix(post.Collection, "content/")
+	if !strings.HasPrefix(collection, "uploaded/") {
+		baseDir = strings.TrimPrefix(collection, "content/")
 	}
-	processedBody = plantuml.ProcessPlantUMLWithBase(processedBody, baseDir)
-	processedBody = processCrossReferences(processedBody, post.Collection)
-	
-	// Use content.html (only content, no sidebar) for iframe display
-	tmpl := template.Must(template.ParseFiles("content.html"))
-	output := template.HTML(string(blackfriday.Run([]byte(processedBody))))
-	tmpl.ExecuteTemplate(w, "content", output)
+	body = diagram.Process(body, baseDir)
+	// Diagrams asked to be inlined stay <img> where the policy strips <svg>
+	if sanitizer.AllowsSVG(collection, source) {
+		body = diagram.InlineSVG(body)
+	}
+	// Synced posts are resolved on import; this covers posts added through /add
+	return ingest.ResolveLinks(body, ingest.File{Collection: collection})
 }
 
 // collectionsHandler returns JSON list of all collections with autoSync flag
 func collectionsHandler(w http.ResponseWriter, r *http.Request) {
-	collections, err := db.GetCollections(collection)
+	collections, err := store.GetCollections()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
@@ -70,6 +85,11 @@ func collectionsHandler(w http.ResponseWriter, r *http.Request) {
 	
 	result := make([]CollectionInfo, 0, len(collections))
 	for _, name := range collections {
+		// Hide collections the caller is not allowed to read
+		if !canReadCollection(r, name) {
+			continue
+		}
+		
 		// Check if collection name starts with "content/" prefix (auto-sync source)
 		autoSync := strings.HasPrefix(name, "content/")
 		
@@ -90,61 +110,68 @@ func collectionContentHandler(w http.ResponseWriter, r *http.Request) {
 	
 	log.Printf("DEBUG: collectionContentHandler called with: '%s'", collectionName)
 	
+	// /content/ names no collection
+	if collectionName == "" {
+		errorNotFoundPage(w)
+		return
+	}
+	
+	// A path that is not a collection may name a post: /content/{collection}/{url}
+	if post, ok := lookupContentPost(collectionName); ok {
+		if !canReadCollection(r, post.Collection) {
+			errorNotFoundPage(w)
+			return
+		}
+		renderPost(w, r, post)
+		return
+	}
+	
+	if !canReadCollection(r, collectionName) {
+		errorNotFoundPage(w)
+		return
+	}
+	
 	// Try to find index.md for this collection
-	indexPost, err := db.GetIndexPost(collection, collectionName)
+	indexPost, err := store.GetIndexPost(collectionName)
+	if err == nil && !canReadCollection(r, indexPost.Collection) {
+		errorNotFoundPage(w)
+		return
+	}
 	
 	var out string
+	page := contentPage{}
 	if err == nil && indexPost.IsIndex {
 		// Found index.md - show it
 		out = indexPost.Body
+		page.Post = &indexPost
+		page.Backlinks, _ = visibleBacklinks(r, indexPost)
 	} else {
-		// No index.md - show list of all posts in collection
-		posts, err := db.GetPostsByCollection(collection, collectionName)
+		// No index.md - show sub-collections and all posts in collection
+		posts, err := store.GetPostsByCollection(collectionName)
 		if err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 		
-		out = "# " + collectionName + "\n---\n"
-		for _, post := range posts {
-			out += "- [" + post.Title + "](/post/" + post.URL + ")\n"
-		}
+		out = sectionListing(r, collectionName, posts)
 	}
 	
-	// Process PlantUML and cross-references (base = collection name without prefix)
-	baseDir := ""
-	if !strings.HasPrefix(collectionName, "uploaded/") {
-		baseDir = strings.TrimPrefix(collectionName, "content/")
+	// Listings are server pages; an index post keeps its own source
+	source := ""
+	if page.Post != nil {
+		source = page.Post.Source
 	}
-	out = plantuml.ProcessPlantUMLWithBase(out, baseDir)
-	out = processCrossReferences(out, collectionName)
+	out = prepareMarkdown(out, collectionName, source)
 	
 	tmpl := template.Must(template.ParseFiles("content.html"))
-	output := template.HTML(string(blackfriday.Run([]byte(out))))
-	if err := tmpl.ExecuteTemplate(w, "content", output); err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	var outline []toc.Entry
+	page.Content, outline = renderDocument(out, collectionName, source)
+	if page.Post != nil {
+		page.TOC = sidebarTOC(outline)
 	}
-}
-
-// processCrossReferences converts relative MD links to absolute URLs
-// Converts: [text](./file.md) -> [text](/post/file)
-// Converts: [text](file.md) -> [text](/post/file)
-func processCrossReferences(body string, currentCollection string) string {
-	// Simple regex replacement for markdown links
-	// Pattern: [text](./something.md) or [text](something.md)
-	lines := strings.Split(body, "\n")
-	for i, line := range lines {
-		// Replace relative links
-		line = strings.ReplaceAll(line, "](./", "](/post/")
-		line = strings.ReplaceAll(line, "](.md)", "]")
-		
-		// Replace .md extension in links
-		if strings.Contains(line, "](/post/") {
-			line = strings.ReplaceAll(line, ".md)", ")")
-		}
-		lines[i] = line
+	if err := tmpl.ExecuteTemplate(w, "content", page); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
-	return strings.Join(lines, "\n")
 }
 
 // getCollectionPostsHandler returns all posts for a collection as JSON
@@ -152,7 +179,12 @@ func getCollectionPostsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	collectionName := vars["name"]
 	
-	posts, err := db.GetPostsByCollection(collection, collectionName)
+	if !canReadCollection(r, collectionName) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	
+	posts, err := store.GetPostsByCollection(collectionName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -162,6 +194,120 @@ func getCollectionPostsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(posts)
 }
 
+// searchHandler runs a full-text query: /api/search?q=&collection=&page=&size=
+// Quoted parts of q are matched as phrases.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	q := strings.TrimSpace(v.Get("q"))
+	if q == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+	
+	page, err := strconv.Atoi(v.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	size, err := strconv.Atoi(v.Get("size"))
+	if err != nil || size < 1 {
+		size = 20
+	}
+	if size > 100 {
+		size = 100
+	}
+	
+	allow := func(collectionName string) bool {
+		return canReadCollection(r, collectionName)
+	}
+	result := index.Search(q, v.Get("collection"), allow, page, size)
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// canReadCollection checks the collection ACL against the request's token.
+// Collections without stored metadata are public.
+func canReadCollection(r *http.Request, collectionName string) bool {
+	// Sub-collections inherit the ACL of their nearest ancestor that has one
+	for name := collectionName; name != ""; name = parentCollection(name) {
+		meta, err := store.GetCollectionMeta(name)
+		if err == db.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to load ACL for '%s': %v", name, err)
+			return false
+		}
+		return auth.CanRead(r.Context(), meta)
+	}
+	return true
+}
+
+// parentCollection returns the enclosing collection name ("" at the top)
+func parentCollection(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// validateACL checks that an ACL is well formed before it is stored
+func validateACL(meta db.CollectionMeta) error {
+	switch meta.Access {
+	case db.AccessPublic, db.AccessAuthenticated:
+		return nil
+	case db.AccessGroups:
+		if len(meta.Groups) == 0 {
+			return fmt.Errorf("access %q needs at least one group", meta.Access)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown access level %q (use public, authenticated or groups)", meta.Access)
+	}
+}
+
+// getCollectionACLHandler returns the ACL of a collection as JSON
+func getCollectionACLHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := mux.Vars(r)["name"]
+	
+	meta, err := store.GetCollectionMeta(collectionName)
+	if err == db.ErrNotFound {
+		meta = db.CollectionMeta{Name: collectionName, Access: db.AccessPublic}
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
+
+// setCollectionACLHandler replaces the ACL of a collection from a JSON body
+func setCollectionACLHandler(w http.ResponseWriter, r *http.Request) {
+	collectionName := mux.Vars(r)["name"]
+	
+	var meta db.CollectionMeta
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		http.Error(w, "Invalid ACL: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	meta.Name = collectionName
+	if err := validateACL(meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := store.SetCollectionMeta(meta); err != nil {
+		http.Error(w, "Failed to save ACL: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+	
+	// Notify connected clients to reload
+	broadcastChange("reload")
+}
+
 func addHandler(w http.ResponseWriter, r *http.Request) {
 	v := r.URL.Query()
 	
@@ -181,8 +327,9 @@ func addHandler(w http.ResponseWriter, r *http.Request) {
 		Body:       v["body"][0],
 		Collection: collectionName,
 		IsIndex:    isIndex,
+		Source:     db.SourceAPI,
 	}
-	_, err := db.InsertPost(collection, post, v["key"][0])
+	err := store.InsertPost(post)
 	if err != nil {
 		w.Write([]byte(err.Error()))
 		return
@@ -242,12 +389,13 @@ func createCollectionHandler(w http.ResponseWriter, r *http.Request) {
 		log.Println("No files uploaded, creating empty collection")
 		post := db.Post{
 			Title:      collectionName,
-			URL:        collectionName + "-index",
+			URL:        ingest.IndexURL(collectionName),
 			Body:       fmt.Sprintf("# %s\n\nNew collection created.", collectionName),
 			Collection: collectionName,
 			IsIndex:    true,
+			Source:     db.SourceUpload,
 		}
-		if _, err := db.InsertPost(collection, post, "124252"); err != nil {
+		if err := store.InsertPost(post); err != nil {
 			log.Printf("Error inserting empty collection: %v", err)
 			http.Error(w, "Failed to create collection: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -285,7 +433,8 @@ func deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	if err := db.DeleteCollection(collection, collectionName); err != nil {
+	// Sub-collections go with their parent
+	if _, err := deleteCollectionTree(collectionName); err != nil {
 		http.Error(w, "Failed to delete collection: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -314,7 +463,7 @@ func uploadFilesHandler(w http.ResponseWriter, r *http.Request) {
 	
 	// If replacing, delete existing posts
 	if replaceExisting {
-		if err := db.DeleteCollection(collection, collectionName); err != nil {
+		if err := store.DeleteCollection(collectionName); err != nil {
 			http.Error(w, "Failed to clear collection: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -356,18 +505,17 @@ func processUploadedFileToCollection(fileHeader *multipart.FileHeader, collectio
 	}
 	defer file.Close()
 	
-	// Only process .md files
-	filename := fileHeader.Filename
-	
-	// Extract filename from full path if present (webkitRelativePath)
-	if strings.Contains(filename, "/") {
-		parts := strings.Split(filename, "/")
-		filename = parts[len(parts)-1]
-		log.Printf("  Extracted filename from path: %s", filename)
+	// Filename contains the full path when uploaded via directory selector (webkitRelativePath);
+	// drop the selected folder so paths are relative to the collection root
+	relPath := fileHeader.Filename
+	if parts := strings.SplitN(relPath, "/", 2); len(parts) == 2 {
+		relPath = parts[1]
+		log.Printf("  Path within collection: %s", relPath)
 	}
 	
-	if !strings.HasSuffix(strings.ToLower(filename), ".md") {
-		log.Printf("  Skipping non-markdown file: %s", filename)
+	// Only process .md files
+	if !ingest.IsMarkdown(relPath) {
+		log.Printf("  Skipping non-markdown file: %s", relPath)
 		return nil // Skip non-markdown files
 	}
 	
@@ -379,80 +527,50 @@ func processUploadedFileToCollection(fileHeader *multipart.FileHeader, collectio
 	
 	log.Printf("  Read %d bytes from file", len(content))
 	
-	// Parse frontmatter or use filename as title
-	title := strings.TrimSuffix(filename, ".md")
-	url := strings.ToLower(strings.ReplaceAll(title, " ", "-"))
-	url = strings.ReplaceAll(url, "_", "-")
-	body := string(content)
-	isIndex := false
+	// Sub-folders become sub-collections, like directories under content/
+	collectionName = filesync.JoinCollection(collectionName, path.Dir(relPath))
 	
-	// Check if this is an index file
-	lowerFilename := strings.ToLower(filename)
-	if lowerFilename == "index.md" || lowerFilename == "readme.md" {
-		isIndex = true
-		url = collectionName + "-index"
-		log.Printf("  Detected index file")
-	}
-	
-	// Parse simple frontmatter if exists
-	if strings.HasPrefix(body, "---") {
-		parts := strings.SplitN(body, "---", 3)
-		if len(parts) >= 3 {
-			frontmatter := parts[1]
-			body = strings.TrimSpace(parts[2])
-			
-			// Extract title from frontmatter
-			for _, line := range strings.Split(frontmatter, "\n") {
-				line = strings.TrimSpace(line)
-				if strings.HasPrefix(line, "title:") {
-					title = strings.TrimSpace(strings.TrimPrefix(line, "title:"))
-					title = strings.Trim(title, "\"")
-					log.Printf("  Extracted title from frontmatter: %s", title)
-					break
-				}
-			}
-		}
-	}
-	
-	// Process PlantUML diagrams and cross-references (use base-aware processor)
-	body = plantuml.ProcessPlantUMLWithBase(body, collectionName)
-	body = processCrossReferences(body, collectionName)
-	
-	// Create post
-	post := db.Post{
-		Title:      title,
-		URL:        url,
-		Body:       body,
+	// Uploaded collections have no directory on disk, so .puml files can't be resolved
+	post, err := pipeline.Ingest(ingest.File{
+		Path:       relPath,
+		Data:       content,
+		Origin:     db.SourceUpload,
 		Collection: collectionName,
-		IsIndex:    isIndex,
+	})
+	if err != nil {
+		return err
 	}
 	
-	log.Printf("  Creating post: title='%s', url='%s', collection='%s', isIndex=%v", title, url, collectionName, isIndex)
+	log.Printf("  Creating post: title='%s', url='%s', collection='%s', isIndex=%v", post.Title, post.URL, collectionName, post.IsIndex)
 	
-	_, err = db.InsertPost(collection, post, "124252")
+	// Uploading the same file again replaces the post
+	err = store.UpsertPost(post)
 	if err != nil {
 		return fmt.Errorf("failed to insert post: %w", err)
 	}
 	
-	log.Printf("  ✓ Successfully inserted post '%s'", title)
+	log.Printf("  ✓ Successfully inserted post '%s'", post.Title)
 	return nil
 }
 
 // syncDirectoryHandler syncs markdown files from content/ directory
 func syncDirectoryHandler(w http.ResponseWriter, r *http.Request) {
 	// Trigger auto-sync from content/ directory
-	if err := autoSyncFromContent(); err != nil {
+	results, err := autoSyncFromContent()
+	if err != nil {
 		http.Error(w, "Sync failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": "Files synced successfully from content/",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "success",
+		"message":   "Files synced successfully from content/",
+		"directory": syncDir,
+		"results":   results,
 	})
 	
 	// Notify connected clients to reload
-	broadcastChange("reload")
+	broadcastPostChanges(results)
 }
 
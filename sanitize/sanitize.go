@@ -0,0 +1,194 @@
+package sanitize
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Policy names
+const (
+	// PolicyStrict keeps markdown output and the markup the server generates
+	// (diagram images, highlighting classes, heading anchors, task lists)
+	PolicyStrict = "strict"
+	// PolicyRelaxed also allows classes and inline styles on any element
+	PolicyRelaxed = "relaxed"
+	// PolicyNone disables sanitization
+	PolicyNone = "none"
+)
+
+// DefaultRules trusts the synced repository more than browser uploads.
+// "*" applies to collections no prefix matches and to server generated pages.
+// Rules weaker than strict only apply to synced posts, see PolicyFor.
+const DefaultRules = "content/=relaxed,uploaded/=strict,*=strict"
+
+// rule maps a collection prefix to a policy
+type rule struct {
+	prefix string
+	policy string
+}
+
+// Sanitizer cleans rendered HTML with a policy chosen by collection prefix and post source
+type Sanitizer struct {
+	rules    []rule // longest prefix first
+	fallback string
+	policies map[string]*bluemonday.Policy
+}
+
+// New parses comma separated prefix=policy rules, e.g. DefaultRules
+func New(rules string) (*Sanitizer, error) {
+	s := &Sanitizer{
+		fallback: PolicyStrict,
+		policies: map[string]*bluemonday.Policy{
+			PolicyStrict:  strictPolicy(),
+			PolicyRelaxed: relaxedPolicy(),
+			PolicyNone:    nil,
+		},
+	}
+	for _, item := range strings.Split(rules, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid sanitize rule %q (want prefix=policy)", item)
+		}
+		prefix, policy := strings.TrimSpace(parts[0]), strings.ToLower(strings.TrimSpace(parts[1]))
+		if _, ok := s.policies[policy]; !ok {
+			return nil, fmt.Errorf("unknown sanitize policy %q (use strict, relaxed or none)", policy)
+		}
+		if prefix == "*" {
+			s.fallback = policy
+			continue
+		}
+		s.rules = append(s.rules, rule{prefix: prefix, policy: policy})
+	}
+	sort.SliceStable(s.rules, func(i, j int) bool {
+		return len(s.rules[i].prefix) > len(s.rules[j].prefix)
+	})
+	return s, nil
+}
+
+// FromEnv creates a sanitizer from SANITIZE_POLICIES, falling back to DefaultRules
+func FromEnv() (*Sanitizer, error) {
+	rules := os.Getenv("SANITIZE_POLICIES")
+	if rules == "" {
+		rules = DefaultRules
+	}
+	return New(rules)
+}
+
+// PolicyFor returns the name of the policy applied to a post from source
+// (db.SourceSync, db.SourceUpload, ...) in collection. Uploads and /add may
+// name any collection, so only synced posts get a policy weaker than strict.
+func (s *Sanitizer) PolicyFor(collection, source string) string {
+	policy := s.fallback
+	for _, r := range s.rules {
+		if strings.HasPrefix(collection, r.prefix) {
+			policy = r.policy
+			break
+		}
+	}
+	if source != db.SourceSync {
+		return PolicyStrict
+	}
+	return policy
+}
+
+// Sanitize cleans HTML rendered from a post in collection, see PolicyFor.
+// Server pages pass "" for both.
+func (s *Sanitizer) Sanitize(collection, source string, html []byte) []byte {
+	policy := s.policies[s.PolicyFor(collection, source)]
+	if policy == nil {
+		return html
+	}
+	return policy.SanitizeBytes(html)
+}
+
+// classNames matches class attributes such as "chroma" or "footnote-ref"
+var classNames = regexp.MustCompile(`^[a-zA-Z0-9_\- ]*$`)
+
+// strictPolicy is bluemonday's user generated content policy plus the markup
+// the renderers and the diagram/highlighting stages produce
+func strictPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	// Syntax highlighting, footnotes and diagrams are styled through classes
+	p.AllowAttrs("class").Matching(classNames).OnElements("pre", "code", "span", "div", "a", "sup", "section", "table", "img", "li", "ul", "ol")
+	// GFM task lists
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+	// Footnotes
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-[a-z]+$`)).OnElements("a", "sup", "div", "section")
+	// Table column alignment
+	p.AllowAttrs("style").OnElements("th", "td")
+	p.AllowStyles("text-align").MatchingEnum("left", "right", "center").OnElements("th", "td")
+	return p
+}
+
+// relaxedPolicy extends strictPolicy for trusted documents
+func relaxedPolicy() *bluemonday.Policy {
+	p := strictPolicy()
+	p.AllowAttrs("class").Matching(classNames).Globally()
+	p.AllowAttrs("style").Globally()
+	p.AllowStyles("color", "background-color", "text-align", "font-weight", "font-style",
+		"text-decoration", "width", "max-width", "height", "margin", "padding", "border",
+		"display", "float").Globally()
+	p.AllowAttrs("target").Matching(regexp.MustCompile(`^_blank$`)).OnElements("a")
+	p.AllowElements("kbd", "mark", "abbr", "small")
+	allowSVG(p)
+	return p
+}
+
+// AllowsSVG reports whether inline <svg> markup survives the policy of a post
+// from source in collection
+func (s *Sanitizer) AllowsSVG(collection, source string) bool {
+	policy := s.PolicyFor(collection, source)
+	return policy == PolicyRelaxed || policy == PolicyNone
+}
+
+// svgElements are the SVG elements diagrams are drawn with; script, style,
+// foreignObject and image are left out
+var svgElements = []string{
+	"svg", "g", "defs", "title", "desc", "symbol", "use", "a",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+	"text", "tspan", "textpath",
+	"lineargradient", "radialgradient", "stop", "marker", "clippath", "mask", "pattern",
+	"filter", "feblend", "fecolormatrix", "fecomposite", "feflood", "fegaussianblur",
+	"femerge", "femergenode", "feoffset",
+}
+
+// svgAttributes are geometry and presentation attributes of svgElements
+var svgAttributes = []string{
+	"viewbox", "preserveaspectratio", "version", "xmlns", "xmlns:xlink", "width", "height",
+	"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "dx", "dy", "d", "points",
+	"transform", "opacity", "fill", "fill-opacity", "fill-rule", "stroke", "stroke-width",
+	"stroke-dasharray", "stroke-dashoffset", "stroke-linecap", "stroke-linejoin", "stroke-opacity",
+	"font-family", "font-size", "font-weight", "font-style", "text-anchor", "dominant-baseline",
+	"textlength", "lengthadjust", "xml:space", "offset", "stop-color", "stop-opacity",
+	"gradientunits", "gradienttransform", "markerwidth", "markerheight", "markerunits",
+	"refx", "refy", "orient", "clip-path", "clippathunits", "mask", "filter", "filterunits",
+	"in", "in2", "result", "mode", "values", "type", "stddeviation", "flood-color", "flood-opacity",
+	"contentstyletype", "zoomandpan",
+}
+
+// svgLink matches link targets allowed in diagrams: web, relative and fragment URLs
+var svgLink = regexp.MustCompile(`^(https?://|/|#|\./|\.\./|[a-zA-Z0-9_\-]+(\.[a-zA-Z0-9]+)?([/?#]|$))`)
+
+// allowSVG lets inline SVG diagrams through a policy
+func allowSVG(p *bluemonday.Policy) {
+	p.AllowElements(svgElements...)
+	// Groups such as <g> and <defs> often come without attributes
+	p.AllowNoAttrs().OnElements(svgElements...)
+	p.AllowAttrs(svgAttributes...).OnElements(svgElements...)
+	// <a href> keeps the policy's URL checks; xlink:href gets the same limits by pattern
+	p.AllowAttrs("xlink:href").Matching(svgLink).OnElements("a", "use")
+	p.AllowAttrs("href").Matching(svgLink).OnElements("use")
+	p.AllowAttrs("xlink:title").OnElements("a")
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)).OnElements(svgElements...)
+}
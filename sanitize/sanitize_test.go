@@ -0,0 +1,38 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+func TestUploadsToTrustedCollectionsStayStrict(t *testing.T) {
+	s, err := New(DefaultRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := []byte(`<p style="color: red">hi</p><svg viewBox="0 0 1 1"><rect width="1" height="1"/></svg>`)
+
+	if got := s.PolicyFor("content/Arch", db.SourceSync); got != PolicyRelaxed {
+		t.Errorf("synced post policy = %s, want %s", got, PolicyRelaxed)
+	}
+	synced := string(s.Sanitize("content/Arch", db.SourceSync, html))
+	if !strings.Contains(synced, "style=") || !strings.Contains(synced, "<svg") {
+		t.Errorf("relaxed policy stripped a synced post: %s", synced)
+	}
+
+	// Uploads and /add can name any collection, including one under content/
+	for _, source := range []string{db.SourceUpload, db.SourceAPI, ""} {
+		if got := s.PolicyFor("content/Arch", source); got != PolicyStrict {
+			t.Errorf("%q post policy = %s, want %s", source, got, PolicyStrict)
+		}
+		if s.AllowsSVG("content/Arch", source) {
+			t.Errorf("%q post may inline SVG", source)
+		}
+		out := string(s.Sanitize("content/Arch", source, html))
+		if strings.Contains(out, "style=") || strings.Contains(out, "<svg") {
+			t.Errorf("%q post was not sanitized strictly: %s", source, out)
+		}
+	}
+}
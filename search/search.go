@@ -0,0 +1,474 @@
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/ingest"
+)
+
+// BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+	// titleBoost counts every title occurrence of a term as this many body occurrences
+	titleBoost = 3
+	// maxTokenLen drops encoded blobs (PlantUML URLs, base64) from the index
+	maxTokenLen = 40
+	// snippetRadius is the number of characters shown on each side of the first match
+	snippetRadius = 80
+)
+
+// Query is a parsed search query: every term and every phrase must match
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+}
+
+// Result is a single ranked search hit
+type Result struct {
+	Title      string  `json:"title"`
+	URL        string  `json:"url"`
+	Collection string  `json:"collection"`
+	Path       string  `json:"path"` // /content/{collection}/{url}
+	Score      float64 `json:"score"`
+	Snippet    string  `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+}
+
+// Page is one page of search results
+type Page struct {
+	Query   string   `json:"query"`
+	Total   int      `json:"total"`
+	Page    int      `json:"page"`
+	Size    int      `json:"size"`
+	Results []Result `json:"results"`
+}
+
+type document struct {
+	post       db.Post
+	length     int
+	titleTerms map[string]int
+	terms      []string // unique terms, used to clean up postings on removal
+}
+
+// Index is an in-memory inverted index with term positions
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string][]int // term -> doc id -> positions
+	totalLen int
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string][]int),
+	}
+}
+
+func docID(collection, url string) string {
+	return collection + "\x00" + url
+}
+
+// Tokenize lowercases text and splits it into words
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := fields[:0]
+	for _, f := range fields {
+		if len(f) <= maxTokenLen {
+			tokens = append(tokens, f)
+		}
+	}
+	return tokens
+}
+
+// ParseQuery splits a query string into terms and "quoted phrases"
+func ParseQuery(q string) Query {
+	var query Query
+	parts := strings.Split(q, `"`)
+	for i, part := range parts {
+		tokens := Tokenize(part)
+		if len(tokens) == 0 {
+			continue
+		}
+		// Odd parts are inside quotes
+		if i%2 == 1 && len(tokens) > 1 {
+			query.Phrases = append(query.Phrases, tokens)
+		} else {
+			query.Terms = append(query.Terms, tokens...)
+		}
+	}
+	return query
+}
+
+// Empty reports whether the query has nothing to search for
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0 && len(q.Phrases) == 0
+}
+
+// allTerms returns the unique terms of the query including phrase words
+func (q Query) allTerms() []string {
+	seen := make(map[string]bool)
+	var terms []string
+	add := func(t string) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	for _, t := range q.Terms {
+		add(t)
+	}
+	for _, p := range q.Phrases {
+		for _, t := range p {
+			add(t)
+		}
+	}
+	return terms
+}
+
+// Add indexes a post, replacing any previous version with the same collection and url
+func (idx *Index) Add(post db.Post) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	id := docID(post.Collection, post.URL)
+	idx.remove(id)
+
+	titleTokens := Tokenize(post.Title)
+	bodyTokens := Tokenize(post.Body)
+	doc := &document{
+		post:       post,
+		length:     len(titleTokens) + len(bodyTokens),
+		titleTerms: make(map[string]int),
+	}
+	for _, t := range titleTokens {
+		doc.titleTerms[t]++
+	}
+
+	// Title and body share one position space with a gap so phrases never span both
+	positions := make(map[string][]int)
+	for i, t := range titleTokens {
+		positions[t] = append(positions[t], i)
+	}
+	offset := len(titleTokens) + 1
+	for i, t := range bodyTokens {
+		positions[t] = append(positions[t], offset+i)
+	}
+	for term, pos := range positions {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string][]int)
+		}
+		idx.postings[term][id] = pos
+		doc.terms = append(doc.terms, term)
+	}
+
+	idx.docs[id] = doc
+	idx.totalLen += doc.length
+}
+
+// Remove drops a post from the index
+func (idx *Index) Remove(collection, url string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(docID(collection, url))
+}
+
+// RemoveCollection drops every post of a collection from the index
+func (idx *Index) RemoveCollection(collection string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for id, doc := range idx.docs {
+		if doc.post.Collection == collection {
+			idx.remove(id)
+		}
+	}
+}
+
+// remove deletes a document; callers must hold the write lock
+func (idx *Index) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLen -= doc.length
+	delete(idx.docs, id)
+}
+
+// Rebuild replaces the index content with the given posts. The new index is
+// built aside, so searches see either the old or the new content, never a partial one.
+func (idx *Index) Rebuild(posts []db.Post) {
+	fresh := NewIndex()
+	for _, post := range posts {
+		fresh.Add(post)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs = fresh.docs
+	idx.postings = fresh.postings
+	idx.totalLen = fresh.totalLen
+}
+
+// Search runs a query. If collection is non-empty only that collection and its
+// sub-collections are searched.
+// allow, if non-nil, is called per collection to filter out hidden results.
+// page is 1-based.
+func (idx *Index) Search(raw string, collection string, allow func(collection string) bool, page, size int) Page {
+	query := ParseQuery(raw)
+	result := Page{Query: raw, Page: page, Size: size, Results: []Result{}}
+	if query.Empty() {
+		return result
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := query.allTerms()
+	candidates := idx.candidates(terms)
+
+	allowed := make(map[string]bool)
+	var hits []Result
+	for id := range candidates {
+		doc := idx.docs[id]
+		if collection != "" && doc.post.Collection != collection && !strings.HasPrefix(doc.post.Collection, collection+"/") {
+			continue
+		}
+		if allow != nil {
+			ok, seen := allowed[doc.post.Collection]
+			if !seen {
+				ok = allow(doc.post.Collection)
+				allowed[doc.post.Collection] = ok
+			}
+			if !ok {
+				continue
+			}
+		}
+		if !idx.matchesPhrases(id, query.Phrases) {
+			continue
+		}
+		hits = append(hits, Result{
+			Title:      doc.post.Title,
+			URL:        doc.post.URL,
+			Collection: doc.post.Collection,
+			Path:       ingest.PostPath(doc.post.Collection, doc.post.URL),
+			Score:      idx.score(id, doc, terms),
+		})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Title < hits[j].Title
+	})
+
+	result.Total = len(hits)
+	start := (page - 1) * size
+	if start > len(hits) {
+		start = len(hits)
+	}
+	end := start + size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	for _, hit := range hits[start:end] {
+		doc := idx.docs[docID(hit.Collection, hit.URL)]
+		hit.Snippet = snippet(doc.post.Body, query)
+		result.Results = append(result.Results, hit)
+	}
+	return result
+}
+
+// candidates returns the ids of documents containing every term
+func (idx *Index) candidates(terms []string) map[string]bool {
+	var smallest map[string][]int
+	for _, t := range terms {
+		p := idx.postings[t]
+		if len(p) == 0 {
+			return nil
+		}
+		if smallest == nil || len(p) < len(smallest) {
+			smallest = p
+		}
+	}
+	result := make(map[string]bool)
+	for id := range smallest {
+		ok := true
+		for _, t := range terms {
+			if _, found := idx.postings[t][id]; !found {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+// matchesPhrases checks that every phrase occurs with consecutive positions
+func (idx *Index) matchesPhrases(id string, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		found := false
+		for _, start := range idx.postings[phrase[0]][id] {
+			ok := true
+			for i := 1; i < len(phrase); i++ {
+				if !containsInt(idx.postings[phrase[i]][id], start+i) {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// score computes the BM25 score of a document for the given terms
+func (idx *Index) score(id string, doc *document, terms []string) float64 {
+	n := float64(len(idx.docs))
+	avgLen := float64(idx.totalLen) / n
+	if avgLen == 0 {
+		avgLen = 1
+	}
+	var score float64
+	for _, t := range terms {
+		df := float64(len(idx.postings[t]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		tf := float64(len(idx.postings[t][id]) + (titleBoost-1)*doc.titleTerms[t])
+		score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(doc.length)/avgLen))
+	}
+	return score
+}
+
+func containsInt(list []int, v int) bool {
+	i := sort.SearchInts(list, v)
+	return i < len(list) && list[i] == v
+}
+
+// snippet returns an escaped excerpt of body around the first match with matches highlighted
+func snippet(body string, query Query) string {
+	text := strings.Join(strings.Fields(body), " ")
+	lower := toLowerSameLen(text)
+
+	// Prefer the first phrase, then the first term that appears in the text
+	var needles []string
+	for _, p := range query.Phrases {
+		needles = append(needles, strings.Join(p, " "))
+	}
+	needles = append(needles, query.allTerms()...)
+
+	pos := -1
+	for _, n := range needles {
+		if i := strings.Index(lower, n); i >= 0 {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		pos = 0
+	}
+
+	start := pos - snippetRadius
+	if start < 0 {
+		start = 0
+	}
+	end := pos + snippetRadius
+	if end > len(text) {
+		end = len(text)
+	}
+	// Do not cut UTF-8 sequences in half
+	for start > 0 && !isRuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !isRuneStart(text[end]) {
+		end++
+	}
+
+	excerpt := highlight(text[start:end], needles)
+	if start > 0 {
+		excerpt = "…" + excerpt
+	}
+	if end < len(text) {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+// highlight escapes text and wraps case-insensitive occurrences of needles in <mark>
+func highlight(text string, needles []string) string {
+	lower := toLowerSameLen(text)
+	marked := make([]bool, len(text))
+	for _, n := range needles {
+		if n == "" {
+			continue
+		}
+		for from := 0; ; {
+			i := strings.Index(lower[from:], n)
+			if i < 0 {
+				break
+			}
+			for j := from + i; j < from+i+len(n); j++ {
+				marked[j] = true
+			}
+			from += i + len(n)
+		}
+	}
+
+	var out strings.Builder
+	inMark := false
+	for i := 0; i < len(text); {
+		if marked[i] != inMark {
+			if marked[i] {
+				out.WriteString("<mark>")
+			} else {
+				out.WriteString("</mark>")
+			}
+			inMark = marked[i]
+		}
+		j := i + 1
+		for j < len(text) && !isRuneStart(text[j]) {
+			j++
+		}
+		out.WriteString(html.EscapeString(text[i:j]))
+		i = j
+	}
+	if inMark {
+		out.WriteString("</mark>")
+	}
+	return out.String()
+}
+
+func isRuneStart(c byte) bool {
+	return c&0xC0 != 0x80
+}
+
+// toLowerSameLen lowercases text unless that would change its byte length,
+// so offsets found in the result are valid in the original
+func toLowerSameLen(text string) string {
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		return text
+	}
+	return lower
+}
@@ -0,0 +1,49 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+func TestSearchCollectionIncludesSubCollections(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(db.Post{Collection: "docs", URL: "intro", Title: "Intro", Body: "deploy the server"})
+	idx.Add(db.Post{Collection: "docs/guides", URL: "deploy", Title: "Deploy", Body: "deploy with docker"})
+	idx.Add(db.Post{Collection: "docs-old", URL: "deploy", Title: "Old deploy", Body: "deploy by hand"})
+
+	page := idx.Search("deploy", "docs", nil, 1, 10)
+	found := map[string]bool{}
+	for _, r := range page.Results {
+		found[r.Collection] = true
+	}
+	if !found["docs"] || !found["docs/guides"] || found["docs-old"] || len(page.Results) != 2 {
+		t.Errorf("search in docs returned %+v, want docs and docs/guides only", page.Results)
+	}
+}
+
+func TestRefreshPicksUpOtherWriters(t *testing.T) {
+	backend := db.NewMemoryStore()
+	indexed, err := NewIndexedStore(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Written around the index, as a CLI sync on a shared store would
+	if err := backend.UpsertPost(db.Post{Collection: "content/Ops guides", URL: "kafka", Title: "Kafka", Body: "rebalance the cluster"}); err != nil {
+		t.Fatal(err)
+	}
+	if page := indexed.Index.Search("rebalance", "", nil, 1, 10); page.Total != 0 {
+		t.Fatalf("index changed before refresh: %+v", page.Results)
+	}
+
+	if err := indexed.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	page := indexed.Index.Search("rebalance", "", nil, 1, 10)
+	if page.Total != 1 {
+		t.Fatalf("refresh did not index the new post: %+v", page.Results)
+	}
+	if want := "/content/content/Ops%20guides/kafka"; page.Results[0].Path != want {
+		t.Errorf("path = %q, want %q", page.Results[0].Path, want)
+	}
+}
@@ -0,0 +1,81 @@
+package search
+
+import "github.com/beldmian/go-markdown-server/db"
+
+// IndexedStore wraps a db.Store and keeps an Index in sync with every write
+type IndexedStore struct {
+	db.Store
+	Index *Index
+}
+
+// NewIndexedStore wraps store and builds the index from its current posts
+func NewIndexedStore(store db.Store) (*IndexedStore, error) {
+	s := &IndexedStore{Store: store, Index: NewIndex()}
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh rebuilds the index from the store, picking up writes made by other
+// processes sharing it (CLI sync and import, other servers)
+func (s *IndexedStore) Refresh() error {
+	posts, err := s.Store.GetPosts()
+	if err != nil {
+		return err
+	}
+	s.Index.Rebuild(posts)
+	return nil
+}
+
+// InsertPost stores and indexes a new post
+func (s *IndexedStore) InsertPost(post db.Post) error {
+	if err := s.Store.InsertPost(post); err != nil {
+		return err
+	}
+	s.Index.Add(post)
+	return nil
+}
+
+// UpsertPost stores and re-indexes a post
+func (s *IndexedStore) UpsertPost(post db.Post) error {
+	if err := s.Store.UpsertPost(post); err != nil {
+		return err
+	}
+	s.Index.Add(post)
+	return nil
+}
+
+// DeleteCollection removes a collection from the store and the index
+func (s *IndexedStore) DeleteCollection(collectionName string) error {
+	if err := s.Store.DeleteCollection(collectionName); err != nil {
+		return err
+	}
+	s.Index.RemoveCollection(collectionName)
+	return nil
+}
+
+// DeletePostByPath removes a post from the store and the index
+func (s *IndexedStore) DeletePostByPath(collectionName string, url string) error {
+	if err := s.Store.DeletePostByPath(collectionName, url); err != nil {
+		return err
+	}
+	s.Index.Remove(collectionName, url)
+	return nil
+}
+
+// RenameCollection renames a collection and re-indexes its posts
+func (s *IndexedStore) RenameCollection(oldName, newName string) error {
+	if err := s.Store.RenameCollection(oldName, newName); err != nil {
+		return err
+	}
+	s.Index.RemoveCollection(oldName)
+	posts, err := s.Store.GetPostsByCollection(newName)
+	if err != nil {
+		return err
+	}
+	for _, post := range posts {
+		s.Index.Add(post)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/gorilla/mux"
+)
+
+// tagSummary is one tag with the number of posts the caller may read
+type tagSummary struct {
+	Tag         string   `json:"tag"`
+	Count       int      `json:"count"`
+	Collections []string `json:"collections"`
+}
+
+// readableFilter returns a per-request, cached canReadCollection
+func readableFilter(r *http.Request) func(collection string) bool {
+	allowed := make(map[string]bool)
+	return func(collection string) bool {
+		ok, seen := allowed[collection]
+		if !seen {
+			ok = canReadCollection(r, collection)
+			allowed[collection] = ok
+		}
+		return ok
+	}
+}
+
+// visibleTags sums tag counts over the collections the caller may read
+func visibleTags(r *http.Request) ([]tagSummary, error) {
+	counts, err := store.GetTagCounts()
+	if err != nil {
+		return nil, err
+	}
+	readable := readableFilter(r)
+	tags := []tagSummary{}
+	for _, c := range counts {
+		if !readable(c.Collection) {
+			continue
+		}
+		// Counts are sorted by tag, so a tag's collections are adjacent
+		if n := len(tags); n > 0 && tags[n-1].Tag == c.Tag {
+			tags[n-1].Count += c.Count
+			tags[n-1].Collections = append(tags[n-1].Collections, c.Collection)
+			continue
+		}
+		tags = append(tags, tagSummary{Tag: c.Tag, Count: c.Count, Collections: []string{c.Collection}})
+	}
+	return tags, nil
+}
+
+// visibleTagPosts returns the posts with a tag that the caller may read
+func visibleTagPosts(r *http.Request, tag string) ([]db.Post, error) {
+	posts, err := store.GetPostsByTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	readable := readableFilter(r)
+	visible := []db.Post{}
+	for _, post := range posts {
+		if readable(post.Collection) {
+			visible = append(visible, post)
+		}
+	}
+	return visible, nil
+}
+
+// tagsAPIHandler returns all tags with post counts
+func tagsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := visibleTags(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// tagPostsAPIHandler returns the posts carrying a tag
+func tagPostsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	posts, err := visibleTagPosts(r, mux.Vars(r)["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// tagsHandler renders the list of all tags
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := visibleTags(r)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var out strings.Builder
+	out.WriteString("# Tags\n\n")
+	if len(tags) == 0 {
+		out.WriteString("No tagged posts yet. Add `tags: [...]` to a post's front matter.\n")
+	}
+	for _, t := range tags {
+		fmt.Fprintf(&out, "- [%s](/tags/%s) (%d)\n", html.EscapeString(t.Tag), url.PathEscape(t.Tag), t.Count)
+	}
+
+	renderMarkdownPage(w, out.String())
+}
+
+// tagHandler renders the posts carrying one tag, grouped by collection
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := db.NormalizeTag(mux.Vars(r)["tag"])
+	posts, err := visibleTagPosts(r, tag)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if len(posts) == 0 {
+		errorNotFoundPage(w)
+		return
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Collection < posts[j].Collection
+	})
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Tag: %s\n\n[All tags](/tags)\n", html.EscapeString(tag))
+	collection := ""
+	for _, post := range posts {
+		if post.Collection != collection {
+			collection = post.Collection
+			fmt.Fprintf(&out, "\n## %s\n\n", html.EscapeString(collection))
+		}
+		fmt.Fprintf(&out, "- [%s](%s)", post.Title, postPath(post))
+		if post.Description != "" {
+			fmt.Fprintf(&out, " — %s", post.Description)
+		}
+		out.WriteString("\n")
+	}
+
+	renderMarkdownPage(w, out.String())
+}
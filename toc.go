@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/beldmian/go-markdown-server/db"
+	"github.com/beldmian/go-markdown-server/toc"
+	"github.com/gorilla/mux"
+)
+
+// tocAPIHandler returns the heading outline of a post
+func tocAPIHandler(w http.ResponseWriter, r *http.Request) {
+	post, err := findPost(r, r.URL.Query().Get("collection"), mux.Vars(r)["url"])
+	if err == db.ErrNotFound {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if err == errAmbiguousPost {
+		http.Error(w, err.Error()+"; pass ?collection=", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, outline := renderDocument(prepareMarkdown(post.Body, post.Collection, post.Source), post.Collection, post.Source)
+	if outline == nil {
+		outline = []toc.Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outline)
+}
@@ -0,0 +1,236 @@
+package toc
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/shurcooL/sanitized_anchor_name"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Placeholder is replaced with the table of contents when it forms its own paragraph
+const Placeholder = "[[toc]]"
+
+// Entry is a heading in the document outline
+type Entry struct {
+	Level    int     `json:"level"`
+	Text     string  `json:"text"`
+	ID       string  `json:"id"`
+	Children []Entry `json:"children,omitempty"`
+}
+
+// Anchor returns the id generated for a heading text
+func Anchor(text string) string {
+	return sanitized_anchor_name.Create(text)
+}
+
+// IDs hands out unique heading ids, appending -1, -2, ... to repeats
+type IDs map[string]int
+
+// Unique returns id, or a numbered variant if it was handed out before
+func (ids IDs) Unique(id string) string {
+	base := id
+	for count, found := ids[id]; found; count, found = ids[id] {
+		ids[base] = count + 1
+		id = fmt.Sprintf("%s-%d", base, count+1)
+	}
+	ids[id] = 0
+	return id
+}
+
+// Process gives every heading in rendered HTML a stable id (keeping explicit
+// ones), replaces the Placeholder paragraph with the table of contents and
+// returns the result with the document outline
+func Process(doc []byte) ([]byte, []Entry, error) {
+	body := &nethtml.Node{Type: nethtml.ElementNode, DataAtom: atom.Body, Data: "body"}
+	nodes, err := nethtml.ParseFragment(bytes.NewReader(doc), body)
+	if err != nil {
+		return doc, nil, err
+	}
+	for _, n := range nodes {
+		body.AppendChild(n)
+	}
+
+	ids := IDs{}
+	var flat []Entry
+	var placeholders []*nethtml.Node
+	var walk func(n *nethtml.Node)
+	walk = func(n *nethtml.Node) {
+		if n.Type == nethtml.ElementNode {
+			if level := headingLevel(n.DataAtom); level > 0 {
+				text := strings.TrimSpace(textContent(n))
+				id := attr(n, "id")
+				if id == "" {
+					id = Anchor(text)
+				}
+				if id != "" {
+					id = ids.Unique(id)
+					setAttr(n, "id", id)
+					flat = append(flat, Entry{Level: level, Text: text, ID: id})
+				}
+				return
+			}
+			if n.DataAtom == atom.P && strings.TrimSpace(textContent(n)) == Placeholder {
+				placeholders = append(placeholders, n)
+				return
+			}
+			if n.DataAtom == atom.Pre || n.DataAtom == atom.Code {
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	outline := Tree(flat)
+	for _, p := range placeholders {
+		nav, err := nethtml.ParseFragment(strings.NewReader(HTML(Visible(outline))), body)
+		if err != nil {
+			return doc, outline, err
+		}
+		for _, n := range nav {
+			p.Parent.InsertBefore(n, p)
+		}
+		p.Parent.RemoveChild(p)
+	}
+
+	var buf bytes.Buffer
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		if err := nethtml.Render(&buf, c); err != nil {
+			return doc, outline, err
+		}
+	}
+	return buf.Bytes(), outline, nil
+}
+
+// HeadingIDs returns the ids Process gives the headings of rendered HTML, in
+// document order. Link checks use it so anchors match the served pages.
+func HeadingIDs(doc []byte) ([]string, error) {
+	_, outline, err := Process(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	var walk func(entries []Entry)
+	walk = func(entries []Entry) {
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+			walk(e.Children)
+		}
+	}
+	walk(outline)
+	return ids, nil
+}
+
+// Tree nests a flat list of headings by level
+func Tree(flat []Entry) []Entry {
+	var build func(i int, level int) ([]Entry, int)
+	build = func(i int, level int) ([]Entry, int) {
+		var entries []Entry
+		for i < len(flat) && flat[i].Level > level {
+			entry := flat[i]
+			entry.Children, i = build(i+1, entry.Level)
+			entries = append(entries, entry)
+		}
+		return entries, i
+	}
+	entries, _ := build(0, 0)
+	return entries
+}
+
+// Visible drops a lone top-level heading (the page title) from an outline
+func Visible(outline []Entry) []Entry {
+	if len(outline) == 1 && outline[0].Level == 1 {
+		return outline[0].Children
+	}
+	return outline
+}
+
+// Count returns the number of entries in an outline
+func Count(outline []Entry) int {
+	n := len(outline)
+	for _, e := range outline {
+		n += Count(e.Children)
+	}
+	return n
+}
+
+// HTML renders an outline as a nested list of links
+func HTML(outline []Entry) string {
+	var buf strings.Builder
+	buf.WriteString(`<nav class="toc">`)
+	writeList(&buf, outline)
+	buf.WriteString(`</nav>`)
+	return buf.String()
+}
+
+// writeList writes one level of the outline
+func writeList(buf *strings.Builder, entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	buf.WriteString("<ul>")
+	for _, e := range entries {
+		fmt.Fprintf(buf, `<li><a href="#%s">%s</a>`, html.EscapeString(e.ID), html.EscapeString(e.Text))
+		writeList(buf, e.Children)
+		buf.WriteString("</li>")
+	}
+	buf.WriteString("</ul>")
+}
+
+// headingLevel returns 1-6 for h1-h6 and 0 otherwise
+func headingLevel(a atom.Atom) int {
+	switch a {
+	case atom.H1:
+		return 1
+	case atom.H2:
+		return 2
+	case atom.H3:
+		return 3
+	case atom.H4:
+		return 4
+	case atom.H5:
+		return 5
+	case atom.H6:
+		return 6
+	}
+	return 0
+}
+
+// textContent concatenates the text below n
+func textContent(n *nethtml.Node) string {
+	if n.Type == nethtml.TextNode {
+		return n.Data
+	}
+	var buf strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(textContent(c))
+	}
+	return buf.String()
+}
+
+// attr returns an attribute value of n
+func attr(n *nethtml.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// setAttr sets an attribute of n
+func setAttr(n *nethtml.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, nethtml.Attribute{Key: key, Val: val})
+}
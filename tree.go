@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+// treeNode is a collection in the navigation tree
+type treeNode struct {
+	Name     string      `json:"name"`            // Full collection name, e.g. "content/Arch/guides"
+	Title    string      `json:"title"`           // Last path segment (full name for top-level nodes)
+	AutoSync bool        `json:"autoSync"`        // Imported from the content/ directory
+	Virtual  bool        `json:"virtual"`         // Directory without posts of its own
+	Index    string      `json:"index,omitempty"` // URL of the section index post
+	Posts    []treePost  `json:"posts"`
+	Children []*treeNode `json:"children"`
+
+	weights map[string]int
+}
+
+// treePost is a post link in the navigation tree
+type treePost struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// buildTree arranges the collections the caller may read into a hierarchy
+func buildTree(r *http.Request) ([]*treeNode, error) {
+	collections, err := store.GetCollections()
+	if err != nil {
+		return nil, err
+	}
+	posts, err := store.GetPosts()
+	if err != nil {
+		return nil, err
+	}
+
+	readable := readableFilter(r)
+	exists := make(map[string]bool, len(collections))
+	for _, name := range collections {
+		exists[name] = true
+	}
+	// hasAncestor reports whether an existing collection encloses name
+	hasAncestor := func(name string) bool {
+		for p := parentCollection(name); p != ""; p = parentCollection(p) {
+			if exists[p] {
+				return true
+			}
+		}
+		return false
+	}
+
+	nodes := make(map[string]*treeNode)
+	roots := []*treeNode{}
+	var ensure func(name string) *treeNode
+	ensure = func(name string) *treeNode {
+		if node, ok := nodes[name]; ok {
+			return node
+		}
+		node := &treeNode{
+			Name:     name,
+			Title:    name,
+			AutoSync: strings.HasPrefix(name, contentPrefix),
+			// Unreadable ancestors of readable collections only show their name
+			Virtual:  !exists[name] || !readable(name),
+			Posts:    []treePost{},
+			Children: []*treeNode{},
+			weights:  make(map[string]int),
+		}
+		nodes[name] = node
+		if hasAncestor(name) {
+			// Directories between two collections appear as virtual nodes
+			parent := ensure(parentCollection(name))
+			node.Title = name[len(parent.Name)+1:]
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+		return node
+	}
+
+	for _, name := range collections {
+		if readable(name) {
+			ensure(name)
+		}
+	}
+	for _, post := range posts {
+		node, ok := nodes[post.Collection]
+		if !ok || node.Virtual {
+			continue
+		}
+		if post.IsIndex {
+			node.Index = post.URL
+			continue
+		}
+		node.Posts = append(node.Posts, treePost{Title: post.Title, URL: post.URL})
+		node.weights[post.URL] = post.Weight
+	}
+
+	sortTree(roots)
+	return roots, nil
+}
+
+// sortTree orders children by title and posts by weight, then title
+func sortTree(nodes []*treeNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Title < nodes[j].Title
+	})
+	for _, node := range nodes {
+		weights := node.weights
+		sort.SliceStable(node.Posts, func(i, j int) bool {
+			a, b := node.Posts[i], node.Posts[j]
+			if weights[a.URL] != weights[b.URL] {
+				return weights[a.URL] < weights[b.URL]
+			}
+			return a.Title < b.Title
+		})
+		sortTree(node.Children)
+	}
+}
+
+// findNode returns the node for a collection name
+func findNode(nodes []*treeNode, name string) *treeNode {
+	for _, node := range nodes {
+		if node.Name == name {
+			return node
+		}
+		if strings.HasPrefix(name, node.Name+"/") {
+			return findNode(node.Children, name)
+		}
+	}
+	return nil
+}
+
+// treeHandler returns the navigation tree of collections, sections and posts
+func treeHandler(w http.ResponseWriter, r *http.Request) {
+	tree, err := buildTree(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// sectionListing renders the generated page of a collection without an index post
+func sectionListing(r *http.Request, collectionName string, posts []db.Post) string {
+	out := "# " + collectionName + "\n---\n"
+	if tree, err := buildTree(r); err == nil {
+		if node := findNode(tree, collectionName); node != nil && len(node.Children) > 0 {
+			out += "\n## Sections\n\n"
+			for _, child := range node.Children {
+				out += "- [" + child.Title + "](/content/" + child.Name + ")\n"
+			}
+			if len(posts) > 0 {
+				out += "\n## Pages\n\n"
+			}
+		}
+	}
+	for _, post := range posts {
+		out += "- [" + post.Title + "](" + postPath(post) + ")\n"
+	}
+	return out
+}
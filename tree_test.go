@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/beldmian/go-markdown-server/db"
+)
+
+func TestTreeHidesRestrictedAncestors(t *testing.T) {
+	store = db.NewMemoryStore()
+	// A public section inside a restricted collection
+	store.SetCollectionMeta(db.CollectionMeta{Name: "docs/internal", Access: db.AccessAuthenticated})
+	store.SetCollectionMeta(db.CollectionMeta{Name: "docs/internal/public", Access: db.AccessPublic})
+	for _, post := range []db.Post{
+		{Collection: "docs/internal", URL: "secret", Title: "Secret plans"},
+		{Collection: "docs/internal", URL: "internal-index", Title: "Internal", IsIndex: true},
+		{Collection: "docs/internal/public", URL: "faq", Title: "FAQ"},
+	} {
+		if err := store.UpsertPost(post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err := buildTree(httptest.NewRequest("GET", "/api/tree", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := findNode(tree, "docs/internal")
+	if parent == nil {
+		t.Fatal("restricted parent of a readable section is missing from the tree")
+	}
+	if !parent.Virtual || len(parent.Posts) != 0 || parent.Index != "" {
+		t.Errorf("restricted parent exposes its posts: virtual=%v posts=%v index=%q", parent.Virtual, parent.Posts, parent.Index)
+	}
+	child := findNode(tree, "docs/internal/public")
+	if child == nil || len(child.Posts) != 1 || child.Posts[0].URL != "faq" {
+		t.Errorf("readable section = %+v, want its FAQ post", child)
+	}
+}
@@ -0,0 +1,312 @@
+package watcher
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch modes
+const (
+	// ModeAuto uses filesystem events plus a slow reconciliation scan
+	ModeAuto = "auto"
+	// ModeEvents uses filesystem events only
+	ModeEvents = "events"
+	// ModePoll rescans the whole tree on every poll interval
+	ModePoll = "poll"
+)
+
+// Options configures a Watcher
+type Options struct {
+	Mode string
+	// Debounce is the quiet period after the last event before a batch is emitted
+	Debounce time.Duration
+	// PollInterval is the scan interval in poll mode
+	PollInterval time.Duration
+	// Rescan is the reconciliation interval in auto mode; 0 disables it
+	Rescan time.Duration
+}
+
+// Batch is the set of markdown changes observed during one debounce window.
+// Paths are absolute or relative exactly as rooted at the watched directory.
+type Batch struct {
+	Added       []string
+	Modified    []string
+	Removed     []string
+	RemovedDirs []string
+}
+
+// Empty reports whether the batch has no changes
+func (b Batch) Empty() bool {
+	return len(b.Added) == 0 && len(b.Modified) == 0 && len(b.Removed) == 0 && len(b.RemovedDirs) == 0
+}
+
+// Watcher reports content changes of markdown files below a root directory
+type Watcher struct {
+	root   string
+	opts   Options
+	hashes map[string]string // tracked .md files -> content hash
+	dirs   map[string]bool   // tracked directories (excluding root)
+}
+
+// New creates a watcher for root. Zero option values get sensible defaults.
+func New(root string, opts Options) *Watcher {
+	if opts.Mode == "" {
+		opts.Mode = ModeAuto
+	}
+	if opts.Debounce <= 0 {
+		opts.Debounce = 300 * time.Millisecond
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 3 * time.Second
+	}
+	return &Watcher{
+		root:   filepath.Clean(root),
+		opts:   opts,
+		hashes: make(map[string]string),
+		dirs:   make(map[string]bool),
+	}
+}
+
+// Run takes an initial snapshot and then calls handle for every non-empty batch.
+// It blocks forever.
+func (w *Watcher) Run(handle func(Batch)) {
+	w.scan() // initial state, not reported
+
+	if w.opts.Mode != ModePoll {
+		if err := w.runEvents(handle); err != nil {
+			log.Printf("File watcher: events unavailable (%v), falling back to polling", err)
+		}
+	}
+	w.runPoll(handle)
+}
+
+// runPoll rescans the tree every poll interval
+func (w *Watcher) runPoll(handle func(Batch)) {
+	log.Printf("File watcher polling %s every %v", w.root, w.opts.PollInterval)
+	ticker := time.NewTicker(w.opts.PollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if batch := w.scan(); !batch.Empty() {
+			handle(batch)
+		}
+	}
+}
+
+// runEvents consumes fsnotify events. It only returns if events cannot be used.
+func (w *Watcher) runEvents(handle func(Batch)) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+
+	if err := w.addDirs(fw, w.root); err != nil {
+		return err
+	}
+	log.Printf("File watcher using filesystem events for %s (%d directories)", w.root, len(w.dirs)+1)
+
+	var rescan <-chan time.Time
+	if w.opts.Mode == ModeAuto && w.opts.Rescan > 0 {
+		ticker := time.NewTicker(w.opts.Rescan)
+		defer ticker.Stop()
+		rescan = ticker.C
+	}
+
+	dirty := make(map[string]bool)
+	timer := time.NewTimer(w.opts.Debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					// Register new directories (and anything created inside them already)
+					if err := w.addDirs(fw, ev.Name); err != nil {
+						log.Printf("File watcher: failed to watch %s: %v", ev.Name, err)
+					}
+				}
+			}
+			dirty[ev.Name] = true
+			timer.Reset(w.opts.Debounce)
+
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			// Overflows lose events; the next flush rescans everything
+			log.Printf("File watcher error: %v", err)
+			dirty[w.root] = true
+			timer.Reset(w.opts.Debounce)
+
+		case <-timer.C:
+			batch := w.refresh(dirty)
+			dirty = make(map[string]bool)
+			if !batch.Empty() {
+				handle(batch)
+			}
+
+		case <-rescan:
+			if batch := w.scan(); !batch.Empty() {
+				log.Printf("File watcher: reconciliation scan found missed changes")
+				handle(batch)
+			}
+		}
+	}
+}
+
+// addDirs registers dir and all its subdirectories with fsnotify
+func (w *Watcher) addDirs(fw *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		return fw.Add(path)
+	})
+}
+
+// refresh re-examines the dirty paths and returns the resulting changes
+func (w *Watcher) refresh(dirty map[string]bool) Batch {
+	if dirty[w.root] {
+		return w.scan()
+	}
+	var batch Batch
+	seen := make(map[string]bool)
+	for path := range dirty {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Gone: either a tracked file or a tracked directory
+			w.forget(path, &batch)
+			continue
+		}
+		if info.IsDir() {
+			w.walk(path, seen, &batch)
+			w.removeMissing(path, seen, &batch)
+			continue
+		}
+		if isMarkdown(path) {
+			seen[path] = true
+			w.check(path, &batch)
+		}
+	}
+	batch.sort()
+	return batch
+}
+
+// scan walks the whole tree and reports differences from the tracked state
+func (w *Watcher) scan() Batch {
+	var batch Batch
+	seen := make(map[string]bool)
+	w.walk(w.root, seen, &batch)
+	w.removeMissing(w.root, seen, &batch)
+	batch.sort()
+	return batch
+}
+
+// walk checks every markdown file and directory under dir
+func (w *Watcher) walk(dir string, seen map[string]bool, batch *Batch) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != w.root {
+				w.dirs[path] = true
+				seen[path] = true
+			}
+			return nil
+		}
+		if isMarkdown(path) {
+			seen[path] = true
+			w.check(path, batch)
+		}
+		return nil
+	})
+}
+
+// check compares a file's hash with the tracked one
+func (w *Watcher) check(path string, batch *Batch) {
+	hash, err := hashFile(path)
+	if err != nil {
+		return
+	}
+	previous, exists := w.hashes[path]
+	switch {
+	case !exists:
+		batch.Added = append(batch.Added, path)
+	case previous != hash:
+		batch.Modified = append(batch.Modified, path)
+	default:
+		return
+	}
+	w.hashes[path] = hash
+}
+
+// removeMissing forgets tracked files and directories under dir that were not seen
+func (w *Watcher) removeMissing(dir string, seen map[string]bool, batch *Batch) {
+	for path := range w.hashes {
+		if within(dir, path) && !seen[path] {
+			delete(w.hashes, path)
+			batch.Removed = append(batch.Removed, path)
+		}
+	}
+	for path := range w.dirs {
+		if within(dir, path) && !seen[path] {
+			delete(w.dirs, path)
+			batch.RemovedDirs = append(batch.RemovedDirs, path)
+		}
+	}
+}
+
+// forget handles a path that no longer exists
+func (w *Watcher) forget(path string, batch *Batch) {
+	if _, ok := w.hashes[path]; ok {
+		delete(w.hashes, path)
+		batch.Removed = append(batch.Removed, path)
+		return
+	}
+	if w.dirs[path] {
+		w.removeMissing(path, map[string]bool{}, batch)
+	}
+}
+
+func (b *Batch) sort() {
+	sort.Strings(b.Added)
+	sort.Strings(b.Modified)
+	sort.Strings(b.Removed)
+	sort.Strings(b.RemovedDirs)
+}
+
+// within reports whether path is dir or below it
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func isMarkdown(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".md")
+}
+
+// hashFile computes MD5 hash of file content
+func hashFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash := md5.Sum(content)
+	return hex.EncodeToString(hash[:]), nil
+}